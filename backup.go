@@ -0,0 +1,130 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Backup writes all files of the db directory to a tar.gz archive.
+// The last entry manifest.txt lists their SHA-256 checksums (sha256sum format).
+func (d DiskDB) Backup(w io.Writer) (n int, e error) {
+	files, e := ioutil.ReadDir(d.dir)
+	if e != nil {
+		return 0, e
+	}
+	z := gzip.NewWriter(w)
+	t := tar.NewWriter(z)
+	var manifest bytes.Buffer
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.Mode().IsRegular() == false || strings.HasPrefix(name, "journal.") || name == "manifest.txt" || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		b, e := ioutil.ReadFile(filepath.Join(d.dir, name))
+		if e != nil {
+			return n, e
+		}
+		if e := writeTar(t, name, b); e != nil {
+			return n, e
+		}
+		fmt.Fprintf(&manifest, "%x  %s\n", sha256.Sum256(b), name)
+		n++
+	}
+	e = writeTar(t, "manifest.txt", manifest.Bytes())
+	e = do(e, t.Close())
+	return n, do(e, z.Close())
+}
+func writeTar(t *tar.Writer, name string, b []byte) error {
+	if e := t.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), Typeflag: tar.TypeReg}); e != nil {
+		return e
+	}
+	_, e := t.Write(b)
+	return e
+}
+
+// Restore extracts a backup into the empty directory dir, after checking the manifest.
+// On error, the extracted files are removed.
+func Restore(r io.Reader, dir string) (n int, e error) {
+	if e := os.MkdirAll(dir, 0755); e != nil {
+		return 0, e
+	}
+	if d, e := ioutil.ReadDir(dir); e != nil {
+		return 0, e
+	} else if len(d) != 0 {
+		return 0, fmt.Errorf("%s: restore: dst is not empty", dir)
+	}
+	sums := make(map[string]string)
+	var manifest []byte
+	defer func() {
+		if e != nil {
+			for name := range sums {
+				os.Remove(filepath.Join(dir, name))
+			}
+		}
+	}()
+	z, e := gzip.NewReader(r)
+	if e != nil {
+		return 0, e
+	}
+	t := tar.NewReader(z)
+	for {
+		h, err := t.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		name := h.Name
+		if h.Typeflag != tar.TypeReg || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return 0, fmt.Errorf("restore: unexpected entry %q", name)
+		}
+		b, err := ioutil.ReadAll(t)
+		if err != nil {
+			return 0, err
+		}
+		if name == "manifest.txt" {
+			manifest = b
+			continue
+		}
+		if _, o := sums[name]; o {
+			return 0, fmt.Errorf("restore: duplicate entry %q", name)
+		}
+		sum := sha256.Sum256(b)
+		sums[name] = hex.EncodeToString(sum[:])
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			return 0, err
+		}
+	}
+	if manifest == nil {
+		return 0, fmt.Errorf("restore: archive has no manifest.txt")
+	}
+	listed := 0
+	s := bufio.NewScanner(bytes.NewReader(manifest))
+	for s.Scan() {
+		v := strings.Fields(s.Text())
+		if len(v) != 2 {
+			continue
+		}
+		listed++
+		if sum, o := sums[v[1]]; o == false {
+			return 0, fmt.Errorf("restore: %s: missing", v[1])
+		} else if sum != v[0] {
+			return 0, fmt.Errorf("restore: %s: checksum mismatch", v[1])
+		}
+	}
+	if listed != len(sums) {
+		return 0, fmt.Errorf("restore: archive has files that are not in the manifest")
+	}
+	syncDir(dir)
+	return len(sums), nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ReadTrack reads a fit, gpx or tcx file depending on the extension.
+// sport overrides the sport of gpx and tcx files, if given.
+// A multisport fit file returns one file per session.
+func ReadTrack(file, sport string) ([]File, error) {
+	if isTrack(file) == false {
+		return nil, fmt.Errorf("%s: unknown file type", file)
+	}
+	b, e := ioutil.ReadFile(file)
+	if e != nil {
+		return nil, e
+	}
+	return readTrack(b, file, sport)
+}
+func readTrack(b []byte, file, sport string) ([]File, error) {
+	var f File
+	var e error
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".fit":
+		return readFit(b)
+	case ".gpx":
+		f, e = readGpx(bytes.NewReader(bytes.TrimSpace(b)), file, sport)
+	case ".tcx":
+		f, e = readTcx(bytes.NewReader(bytes.TrimSpace(b)), file, sport)
+	default:
+		return nil, fmt.Errorf("%s: unknown file type", file)
+	}
+	return []File{f}, e
+}
+func isTrack(file string) bool {
+	x := strings.ToLower(filepath.Ext(file))
+	return x == ".fit" || x == ".gpx" || x == ".tcx"
+}
+
+// has is true if id or its ±1h (dst) variant is in the index.
+func (d DiskDB) has(id int64) bool {
+	return d.find(id) >= 0 || d.find(id-3600) >= 0 || d.find(id+3600) >= 0
+}
+
+// Batch adds all track files in dir that are not in the db yet. Files are decoded in parallel.
+// It writes one line per file and returns the numbers of added, skipped and failed files.
+func (d *DiskDB) Batch(dir, sport string, w io.Writer) (added, skipped, failed int, e error) {
+	files, e := os.ReadDir(dir)
+	if e != nil {
+		return 0, 0, 0, e
+	}
+	var names []string
+	for _, fi := range files {
+		if fi.IsDir() == false && isTrack(fi.Name()) {
+			names = append(names, filepath.Join(dir, fi.Name()))
+		}
+	}
+	sort.Strings(names)
+	type result struct {
+		name string
+		f    []File
+		e    error
+	}
+	in, out := make(chan string), make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range in {
+				f, e := ReadTrack(name, sport)
+				out <- result{name, f, e}
+			}
+		}()
+	}
+	go func() {
+		for _, name := range names {
+			in <- name
+		}
+		close(in)
+		wg.Wait()
+		close(out)
+	}()
+	for r := range out {
+		if r.e == nil && r.f[0].Start == 0 {
+			r.e = fmt.Errorf("no start time")
+		}
+		if r.e != nil {
+			fmt.Fprintf(w, "fail %s: %s\n", r.name, r.e)
+			failed++
+		} else if d.has(r.f[0].Start) {
+			fmt.Fprintf(w, "skip %s: %d exists\n", r.name, r.f[0].Start)
+			skipped++
+		} else if e := d.AddGroup(r.f); e != nil {
+			fmt.Fprintf(w, "fail %s: %s\n", r.name, e)
+			failed++
+		} else {
+			for _, f := range r.f {
+				fmt.Fprintf(w, "a %d %s\n", f.Start, r.name)
+			}
+			added++
+		}
+	}
+	return added, skipped, failed, nil
+}
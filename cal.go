@@ -4,11 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"html/template"
 	"image"
 	"image/color"
 	"image/png"
 	"io"
 	"math"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -19,6 +21,7 @@ type Cal []Week // calendar
 type Week struct {
 	YearWeek
 	Day   [7][]Header
+	Meta  [7][]Meta // parallel to Day
 	Races []Race
 }
 
@@ -32,8 +35,8 @@ func Calendar(db DB) (cal Cal) {
 	if n == 0 {
 		return nil
 	}
-	t := unix(db.Head(0).Start)
-	last := unix(db.Head(n - 1).Start)
+	t := db.Head(0).Local()
+	last := db.Head(n - 1).Local()
 	for i := 0; ; i++ {
 		y, w := t.ISOWeek()
 		cal = append(cal, Week{YearWeek: YearWeek{y, w}})
@@ -48,9 +51,14 @@ func Calendar(db DB) (cal Cal) {
 		k := m[h.yearweek()]
 		d := h.day()
 		cal[k].Day[d] = append(cal[k].Day[d], h)
+		cal[k].Meta[d] = append(cal[k].Meta[d], MetaOf(db, h.Start))
 	}
 	for _, r := range db.Races() {
-		y, w := unix(r.Start).ISOWeek()
+		h := Header{Start: r.Start, Zone: noZone}
+		if a, e := FindH(db, r.Id); r.Id != 0 && e == nil {
+			h = a // the week of its activity
+		}
+		y, w := h.Local().ISOWeek()
 		wk := YearWeek{y, w}
 		if k, o := m[wk]; o {
 			cal[k].Races = append(cal[k].Races, r)
@@ -70,29 +78,51 @@ func (c Cal) Write(w io.Writer, html bool, hi int) {
 		}
 	}
 	bar := func(x float64, c byte) string { return strings.Repeat(string(c), int(math.Round(x))) }
-	var ids []int64
-	var tip []string
+	var ids, tip []string
+	event := c.events()
+	var kind, bars []int // sport index of links and bar blocks (html)
 	tw := tabwriter.NewWriter(w, 2, 0, 3, ' ', 0)
 	th, tkm, trkm, tbkm := 0.0, 0.0, 0.0, 0.0
 	for _, wk := range c {
 		fmt.Fprintf(tw, "%04d/%02d\t", wk.Year, wk.Week)
 		for i := 0; i < 7; i++ {
-			s, id := links(wk.Day[i])
-			ids = append(ids, id...)
-			for _, h := range wk.Day[i] {
-				date := unix(h.Start).Format("2006.01.02")
-				tip = append(tip, fmt.Sprintf("%s %.0fkm %v", date, h.Meters/1000, time.Duration(h.Seconds)*time.Second))
+			s := links(wk.Day[i], html)
+			for j, h := range wk.Day[i] {
+				id := strconv.FormatInt(h.Start, 10)
+				if g := wk.Meta[i][j].Group; g != 0 {
+					id = event[g]
+				}
+				ids = append(ids, id)
+				kind = append(kind, sportOf(h.Type))
+				date := h.Local().Format("2006.01.02")
+				t := fmt.Sprintf("%s %.0fkm %v %s", date, h.Meters/1000, time.Duration(h.Seconds)*time.Second, wk.Meta[i][j].String())
+				tip = append(tip, template.HTMLEscapeString(strings.TrimSpace(t)))
 			}
 			fmt.Fprintf(tw, "%s\t", s)
 		}
 		rs := ""
 		if html {
 			for _, r := range wk.Races {
-				rs += fmt.Sprintf("<a_id=\"race#%d\">%s</a>", r.Start, strings.ToLower(r.Name))
+				if r.Id != 0 { // map of the linked activity
+					rs += fmt.Sprintf("<a_id=\"%d\">%s</a>", r.Id, strings.ToLower(r.Name))
+				} else {
+					rs += fmt.Sprintf("<a_id=\"race#%d\">%s</a>", r.Start, strings.ToLower(r.Name))
+				}
+			}
+		}
+		h, km, rkm, bkm, hs := weekly(wk.Day[:])
+		hist := ""
+		for k := len(hs) - 1; k >= 0; k-- {
+			if html {
+				b := bar(hs[k], 2)
+				hist += b
+				for range b {
+					bars = append(bars, k)
+				}
+			} else {
+				hist += bar(hs[k], sports[k].Letter)
 			}
 		}
-		h, km, rkm, bkm, hs, hr, hb := weekly(wk.Day[:])
-		hist := bar(hs, 'X') + bar(hb, 'Y') + bar(hr, 'Z')
 		th, tkm, trkm, tbkm = th+h, tkm+km, trkm+rkm, tbkm+bkm
 		fmt.Fprintf(tw, "%.1f\t%.0f\t%.0f\t%.0f\t%s\t%s\n", h, km, rkm, bkm, hist, rs)
 	}
@@ -101,12 +131,9 @@ func (c Cal) Write(w io.Writer, html bool, hi int) {
 	tw.Flush()
 
 	if html {
-		o.Write([]byte(calHead))
+		fmt.Fprintf(o, calHead, sportCSS())
 		s := bufio.NewScanner(&b)
-		k := 0
-		f := func(s string, id int64, t string) {
-			fmt.Fprintf(o, `<a class="%s" id="%d" title="%s">%s</a>`, s, id, t, s)
-		}
+		k, j := 0, 0
 		i := 0
 		for s.Scan() {
 			t := strings.Replace(s.Text(), " ", "&nbsp;", -1)
@@ -116,21 +143,12 @@ func (c Cal) Write(w io.Writer, html bool, hi int) {
 			}
 			for _, c := range []byte(t) {
 				switch c {
-				case 'S':
-					f("S", ids[k], tip[k])
-					k++
-				case 'R':
-					f("R", ids[k], tip[k])
-					k++
-				case 'B':
-					f("B", ids[k], tip[k])
+				case 1: // link
+					fmt.Fprintf(o, `<a class="s%d" id="%s" title="%s">%c</a>`, kind[k], ids[k], tip[k], sports[kind[k]].Letter)
 					k++
-				case 'X':
-					o.WriteString(`<a class="S">█</a>`)
-				case 'Y':
-					o.WriteString(`<a class="B">█</a>`)
-				case 'Z':
-					o.WriteString(`<a class="R">█</a>`)
+				case 2: // bar
+					fmt.Fprintf(o, `<a class="s%d">█</a>`, bars[j])
+					j++
 				default:
 					o.WriteByte(c)
 				}
@@ -150,54 +168,58 @@ func (c Cal) WriteStrip(w io.Writer) error { // png image 50xWeeks
 	y := 0
 	for i := len(c) - 1; i >= 0; i-- {
 		wk := c[i]
-		var s, b, r float64
-		for _, d := range wk.Day {
-			for _, h := range d {
-				switch h.Type {
-				case 1:
-					r += float64(h.Seconds)
-				case 2:
-					b += float64(h.Seconds)
-				case 5:
-					s += float64(h.Seconds)
-				}
-			}
-		}
+		_, _, _, _, hs := weekly(wk.Day[:])
 		x := 0
 		draw := func(h float64, c color.RGBA) {
-			for k := 0; k < int(math.Round(h/3600)); k++ {
+			for k := 0; k < int(math.Round(h)); k++ {
 				m.SetRGBA(x, y, c)
 				x++
 			}
 		}
-		draw(s, blue)
-		draw(b, green)
-		draw(r, red)
+		for k := len(hs) - 1; k >= 0; k-- {
+			draw(hs[k], sports[k].Color)
+		}
 		y++
 	}
 	return png.Encode(w, m)
 }
-func links(heads []Header) (s string, ids []int64) {
+func links(heads []Header, html bool) (s string) {
 	for _, h := range heads {
-		s += string(sport(h.Type))
-		ids = append(ids, h.Start)
+		if html {
+			s += "\x01" // replaced by the link
+		} else {
+			s += string(sport(h.Type))
+		}
+	}
+	return s
+}
+
+// events maps a group (see Meta) to the ids of its activities, e.g. "1440832484,1440844224".
+func (c Cal) events() map[int64]string {
+	m := make(map[int64]string)
+	for _, wk := range c {
+		for i := range wk.Day {
+			for j, h := range wk.Day[i] {
+				if g := wk.Meta[i][j].Group; g != 0 {
+					m[g] = strings.TrimPrefix(m[g]+","+strconv.FormatInt(h.Start, 10), ",")
+				}
+			}
+		}
 	}
-	return s, ids
+	return m
 }
-func weekly(a [][]Header) (hours, km, Rkm, Bkm, hs, hr, hb float64) {
+func weekly(a [][]Header) (hours, km, Rkm, Bkm float64, hs []float64) { // hs: hours per sport (index in sports)
+	hs = make([]float64, len(sports))
 	for _, heads := range a {
 		for _, h := range heads {
 			t, d := float64(h.Seconds)/3600, float64(h.Meters)/1000
 			hours += t
 			km += d
-			if h.Type == 1 {
+			hs[sportOf(h.Type)] += t
+			if h.Type&0xff == 1 {
 				Rkm += d
-				hr += t
-			} else if h.Type == 2 {
+			} else if h.Type&0xff == 2 {
 				Bkm += d
-				hb += t
-			} else if h.Type == 5 {
-				hs += t
 			}
 		}
 	}
@@ -205,11 +227,11 @@ func weekly(a [][]Header) (hours, km, Rkm, Bkm, hs, hr, hb float64) {
 }
 
 func (h Header) yearweek() YearWeek {
-	y, w := unix(h.Start).ISOWeek()
+	y, w := h.Local().ISOWeek()
 	return YearWeek{y, w}
 }
 func (h Header) day() int { // 0..6 mon..sun
-	n := int(unix(h.Start).Weekday() - 1)
+	n := int(h.Local().Weekday() - 1)
 	if n < 0 {
 		n += 7
 	}
@@ -222,10 +244,7 @@ const calHead = `<!DOCTYPE html>
 <link rel=icon href='favicon.png' />
 <style>
  html{font-family:monospace}
- .S{color:blue}
- .R{color:red}
- .B{color:green}
- .hi{background:purple;color:white}
+%s .hi{background:purple;color:white}
 </style>
 </head><body>
 <pre>`
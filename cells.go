@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Cells is the spatial index of a DiskDB: the map cells that each track touches.
+// A cell is a zoom level 12 tile of the web mercator projection (x>>20, y>>20).
+//
+// It is stored in db/cells.txt, one line per track: id cell.. (hex).
+// Add appends to it and missing tracks are indexed when it is loaded.
+// It only selects candidates; entries of removed ids are harmless.
+// Merge and Split drop the lines of the tracks they change, which are then indexed again.
+type Cells struct {
+	m   map[uint32][]int64
+	ids map[int64]bool
+}
+
+func (d DiskDB) cellpath() string { return filepath.Join(d.dir, "cells.txt") }
+
+// Cells loads the spatial index on first use.
+func (d *DiskDB) Cells() (*Cells, error) {
+	c := d.cells
+	if c.m != nil {
+		return c, nil
+	}
+	c.m, c.ids = make(map[uint32][]int64), make(map[int64]bool)
+	if fp, e := os.Open(d.cellpath()); e == nil {
+		s := bufio.NewScanner(fp)
+		s.Buffer(nil, 1<<24)
+		for s.Scan() {
+			v := strings.Fields(s.Text())
+			if len(v) == 0 {
+				continue
+			}
+			id, e := strconv.ParseInt(v[0], 10, 64)
+			if e != nil {
+				fp.Close()
+				return nil, fmt.Errorf("%s: %s", d.cellpath(), e)
+			}
+			c.ids[id] = true
+			for _, x := range v[1:] {
+				if u, e := strconv.ParseUint(x, 16, 32); e == nil {
+					c.m[uint32(u)] = append(c.m[uint32(u)], id)
+				}
+			}
+		}
+		fp.Close()
+	} else if os.IsNotExist(e) == false {
+		return nil, e
+	}
+	for i, h := range d.index {
+		if h.Samples > 0 && c.ids[h.Start] == false {
+			if f, e := d.File(i); e == nil {
+				c.add(d, f)
+			}
+		}
+	}
+	return c, nil
+}
+func (c *Cells) add(d *DiskDB, f File) {
+	m := make(map[uint32]bool)
+	u := f.WebMercator()
+	for i := 0; i < len(u); i += 2 {
+		m[cell(u[i], u[i+1])] = true
+	}
+	line := strconv.FormatInt(f.Start, 10)
+	for k := range m {
+		c.m[k] = append(c.m[k], f.Start)
+		line += " " + strconv.FormatUint(uint64(k), 16)
+	}
+	c.ids[f.Start] = true
+	if fp, e := os.OpenFile(d.cellpath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); e == nil {
+		fmt.Fprintln(fp, line)
+		fp.Close()
+	}
+}
+func cell(x, y uint32) uint32 { return x>>20<<12 | y>>20 }
+
+// Rect returns the ids of tracks that may have points within the rectangle (degrees).
+// It returns nil, false if the rectangle covers too many cells.
+func (c *Cells) Rect(n, s, w, e float64) (map[int64]bool, bool) {
+	x0, y0, o0 := mercator(Semis(n), Semis(w))
+	x1, y1, o1 := mercator(Semis(s), Semis(e))
+	if !o0 || !o1 || x1 < x0 || y1 < y0 {
+		return nil, false
+	}
+	x0, y0, x1, y1 = x0>>20, y0>>20, x1>>20, y1>>20
+	if (x1-x0+1)*(y1-y0+1) > 4096 {
+		return nil, false
+	}
+	r := make(map[int64]bool)
+	for x := x0; x <= x1; x++ {
+		for y := y0; y <= y1; y++ {
+			for _, id := range c.m[x<<12|y] {
+				r[id] = true
+			}
+		}
+	}
+	return r, true
+}
+
+// Near returns the part of db that may have points within the rectangle.
+// It uses the spatial index, if db is backed by a DiskDB.
+func Near(db DB, n, s, w, e float64) DB {
+	d := disk(db)
+	if d == nil {
+		return db
+	}
+	c, err := d.Cells()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return db
+	}
+	ids, o := c.Rect(n, s, w, e)
+	if o == false {
+		return db
+	}
+	return FilterH(db, func(h Header) bool { return ids[h.Start] })
+}
+
+// NearPoint is Near for a square around lat, lon (degrees) with half side length m (meters).
+func NearPoint(db DB, lat, lon, m float64) DB {
+	dlat := 180 * m / (math.Pi * EarthRadius)
+	dlon := dlat / math.Cos(rad(lat))
+	return Near(db, lat+dlat, lat-dlat, lon-dlon, lon+dlon)
+}
+
+// disk returns the DiskDB that backs db or nil.
+func disk(db DB) *DiskDB {
+	switch d := db.(type) {
+	case *DiskDB:
+		return d
+	case SubDB:
+		return disk(d.d)
+	}
+	return nil
+}
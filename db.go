@@ -7,7 +7,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -22,12 +24,15 @@ type DiskDB struct {
 	dir   string
 	index []Header
 	races []Race
+	meta  map[int64]Meta
+	gear  []Gear
+	cells *Cells // loaded on first use
 }
 
 func (d DiskDB) Len() int          { return len(d.index) }
 func (d DiskDB) Head(i int) Header { return d.index[i] }
 func (d DiskDB) File(i int) (File, error) {
-	b, e := ioutil.ReadFile(filepath.Join(d.dir, strconv.FormatInt(d.index[i].Start, 10)))
+	b, e := ioutil.ReadFile(d.idpath(d.index[i].Start))
 	if e != nil {
 		return File{}, e
 	}
@@ -35,73 +40,274 @@ func (d DiskDB) File(i int) (File, error) {
 }
 func (d DiskDB) Races() []Race          { return d.races }
 func (d DiskDB) indexpath() string      { return filepath.Join(d.dir, "index.txt") }
-func (d DiskDB) filepath(f File) string { return filepath.Join(d.dir, strconv.FormatInt(f.Start, 10)) }
+func (d DiskDB) filepath(f File) string { return d.idpath(f.Start) }
+func (d DiskDB) idpath(id int64) string { return filepath.Join(d.dir, strconv.FormatInt(id, 10)) }
 func (d DiskDB) racepath() string       { return filepath.Join(d.dir, "race.txt") }
-func (d DiskDB) Add(f File) error {
-	for i := 0; i < d.Len(); i++ {
-		if d.Head(i).Start == f.Start {
+
+// Add writes the track file and inserts the header into the index in one transaction (see tx).
+// The index stays sorted by start time: a new last entry is appended, otherwise the index is rewritten.
+func (d *DiskDB) Add(f File) error {
+	if d.find(f.Start) >= 0 {
+		return fmt.Errorf("%d: file already exists in index", f.Start)
+	}
+	i := sort.Search(len(d.index), func(i int) bool { return d.index[i].Start > f.Start })
+	index := make([]Header, 0, 1+len(d.index))
+	index = append(append(append(index, d.index[:i]...), f.Header), d.index[i:]...)
+	t := d.begin()
+	var e error
+	if f.Samples > 0 {
+		e = t.put(f)
+	}
+	if i == len(d.index) {
+		t.append(f.Header)
+	} else {
+		e = do(e, t.index(index))
+	}
+	if e != nil {
+		t.abort()
+		return e
+	}
+	if e := t.commit(); e != nil {
+		return e
+	}
+	d.index = index
+	d.link()
+	if d.cells.m != nil && f.Samples > 0 {
+		d.cells.add(d, f)
+	}
+	return nil
+}
+
+// AddGroup adds the files of an event, e.g. the sessions of a multisport fit file, in one transaction.
+// Their metadata links them with the group key, the id of the first file.
+func (d *DiskDB) AddGroup(fs []File) error {
+	if len(fs) == 1 {
+		return d.Add(fs[0])
+	}
+	g := fs[0].Start
+	m := make(map[int64]Meta)
+	for _, f := range fs {
+		if f.Start < g {
+			g = f.Start
+		}
+	}
+	for _, f := range fs {
+		m[f.Start] = Meta{Group: g}
+	}
+	return d.AddMeta(fs, m)
+}
+
+// AddMeta adds files and their metadata in one transaction.
+func (d *DiskDB) AddMeta(fs []File, m map[int64]Meta) error {
+	fs = append([]File{}, fs...)
+	sort.Slice(fs, func(i, j int) bool { return fs[i].Start < fs[j].Start })
+	index := append([]Header{}, d.index...)
+	meta := make(map[int64]Meta)
+	for k, x := range d.meta {
+		meta[k] = x
+	}
+	t := d.begin()
+	var e error
+	for k, f := range fs {
+		if d.find(f.Start) >= 0 || k > 0 && f.Start == fs[k-1].Start {
+			t.abort()
 			return fmt.Errorf("%d: file already exists in index", f.Start)
 		}
+		i := sort.Search(len(index), func(i int) bool { return index[i].Start > f.Start })
+		index = append(append(append([]Header{}, index[:i]...), f.Header), index[i:]...)
+		if f.Samples > 0 {
+			e = do(e, t.put(f))
+		}
+		if x := m[f.Start]; x.Empty() == false {
+			meta[f.Start] = x
+		}
+	}
+	e = do(e, t.index(index))
+	if len(m) > 0 {
+		e = do(e, t.metadata(meta))
 	}
-	fp, e := os.OpenFile(d.indexpath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if e != nil {
+		t.abort()
 		return e
 	}
-	defer fp.Close()
-	_, e = fmt.Fprintln(fp, f.Indexline())
+	if e := t.commit(); e != nil {
+		return e
+	}
+	d.index, d.meta = index, meta
+	d.link()
+	for _, f := range fs {
+		if d.cells.m != nil && f.Samples > 0 {
+			d.cells.add(d, f)
+		}
+	}
+	return nil
+}
+
+// Remove deletes the entry id from the index, its track file and a race keyed by the same start.
+// Races that were linked to it are kept without a link.
+func (d *DiskDB) Remove(id int64) error {
+	i := d.find(id)
+	if i < 0 {
+		return fmt.Errorf("id not found: %d", id)
+	}
+	index := append(append([]Header{}, d.index[:i]...), d.index[i+1:]...)
+	var races []Race // races linked to id stay, link clears their Id
+	for _, r := range d.races {
+		if r.Start != id {
+			races = append(races, r)
+		}
+	}
+	meta := d.meta
+	t := d.begin()
+	e := t.index(index)
+	if len(races) != len(d.races) {
+		e = do(e, t.races(races))
+	}
+	if _, o := d.meta[id]; o {
+		meta = make(map[int64]Meta)
+		for k, x := range d.meta {
+			if k != id {
+				meta[k] = x
+			}
+		}
+		e = do(e, t.metadata(meta))
+	}
 	if e != nil {
+		t.abort()
+		return e
+	}
+	t.rm(id)
+	if e := t.commit(); e != nil {
 		return e
 	}
-	if f.Samples == 0 {
-		return nil
+	d.index, d.races, d.meta = index, races, meta
+	d.link()
+	return nil
+}
+
+// Set changes header fields of entry id, e.g. "type=B", "zone=2h" or "meters=10000,seconds=39m2s".
+// It rewrites the index and the track file.
+// Metadata is set one field at a time: "title=..", "note=..", "tags=a,b", "gear=name", "group=id" or "climb=850".
+// The value is the rest of the string, a header key after it is an error.
+func (d *DiskDB) Set(id int64, s string) error {
+	i := d.find(id)
+	if i < 0 {
+		return fmt.Errorf("id not found: %d", id)
+	}
+	if v := strings.SplitN(s, "=", 2); len(v) == 2 && (v[0] == "title" || v[0] == "note" || v[0] == "tags" || v[0] == "gear" || v[0] == "group" || v[0] == "climb") {
+		for _, k := range []string{"type", "meters", "seconds", "zone"} { // metadata values may contain commas
+			if strings.Contains(v[1], ","+k+"=") {
+				return fmt.Errorf("set: %s cannot be combined with header keys (%s), set them separately", v[0], k)
+			}
+		}
+		if v[0] == "gear" && v[1] != "" && d.findGear(v[1]) < 0 {
+			return fmt.Errorf("gear not found: %s", v[1])
+		}
+		m := d.meta[id]
+		m.Tags = append([]string{}, m.Tags...)
+		if e := m.set(v[0], v[1]); e != nil {
+			return e
+		}
+		return d.PutMeta(map[int64]Meta{id: m})
+	}
+	h := d.index[i]
+	for _, kv := range strings.Split(s, ",") {
+		v := strings.SplitN(kv, "=", 2)
+		if len(v) != 2 {
+			return fmt.Errorf("set: expected key=value (got %s)", kv)
+		}
+		var e error
+		switch v[0] {
+		case "type":
+			h.Type, e = parseSport(v[1])
+		case "meters":
+			var f float64
+			f, e = strconv.ParseFloat(v[1], 32)
+			h.Meters = float32(f)
+		case "seconds":
+			var f float64
+			if f, e = strconv.ParseFloat(v[1], 32); e != nil {
+				var t time.Duration
+				t, e = time.ParseDuration(v[1])
+				f = t.Seconds()
+			}
+			h.Seconds = float32(f)
+		case "zone": // utc offset, e.g. 2h or -4h30m, "-": configured zone
+			h.Zone = noZone
+			if v[1] != "-" {
+				var t time.Duration
+				if t, e = time.ParseDuration(v[1]); e == nil && (t < -14*time.Hour || t > 14*time.Hour) {
+					e = fmt.Errorf("offset out of range")
+				}
+				h.Zone = int32(t / time.Second)
+			}
+		default:
+			e = fmt.Errorf("unknown key (type|meters|seconds|zone)")
+		}
+		if e != nil {
+			return fmt.Errorf("set %s: %s", kv, e)
+		}
+	}
+	index := append([]Header{}, d.index...)
+	index[i] = h
+	t := d.begin()
+	e := t.index(index)
+	if h.Samples > 0 {
+		f, err := d.File(i)
+		if err != nil {
+			t.abort()
+			return err
+		}
+		f.Header = h
+		e = do(e, t.put(f))
 	}
-	w, e := os.Create(d.filepath(f))
 	if e != nil {
+		t.abort()
+		return e
+	}
+	if e := t.commit(); e != nil {
 		return e
 	}
-	defer w.Close()
-	return f.Encode(w)
+	d.index = index
+	return nil
+}
+func (d DiskDB) find(id int64) int {
+	if i, o := search(d, id); o {
+		return i
+	}
+	return -1
+}
+
+// search finds id with binary search. All DBs are ordered by start time.
+func search(db DB, id int64) (int, bool) {
+	n := db.Len()
+	i := sort.Search(n, func(i int) bool { return db.Head(i).Start >= id })
+	return i, i < n && db.Head(i).Start == id
 }
 
 func FindH(db DB, id int64) (h Header, e error) {
-	for i := 0; i < db.Len(); i++ {
-		h := db.Head(i)
-		if h.Start == id {
-			return h, nil
-		}
+	if i, o := search(db, id); o {
+		return db.Head(i), nil
 	}
 	return Header{}, fmt.Errorf("id not found: %d", id)
 }
 func Find(db DB, id int64) (f File, e error) {
-	for i := 0; i < db.Len(); i++ {
-		h := db.Head(i)
-		if h.Start == id {
-			return db.File(i)
-		}
+	if i, o := search(db, id); o {
+		return db.File(i)
 	}
 	return f, fmt.Errorf("id not found: %d", id)
 }
 func NextId(db DB, id int64, prev bool) int64 {
-	n := db.Len()
-	for i := 0; i < n; i++ {
-		h := db.Head(i)
-		if h.Start == id {
-			if prev {
-				if i == 0 {
-					return id
-				} else {
-					return db.Head(i - 1).Start
-				}
-			} else {
-				if i == n-1 {
-					return id
-				} else {
-					return db.Head(i + 1).Start
-				}
-			}
-		}
+	i, o := search(db, id)
+	if o == false {
+		return 0
+	}
+	if prev && i > 0 {
+		return db.Head(i - 1).Start
+	} else if !prev && i < db.Len()-1 {
+		return db.Head(i + 1).Start
 	}
-	return 0 // not reached
+	return id
 }
 func Each(db DB, g func(i int, f File)) {
 	for i := 0; i < db.Len(); i++ {
@@ -135,13 +341,17 @@ func Totals(db DB) (n int, t time.Duration, km float64, samples uint64) {
 	return
 }
 func Years(db DB) {
-	R := make(map[int]float64)
-	B := make(map[int]float64)
+	K := make([]map[int]float64, len(sports)) // km per sport
 	H := make(map[int]time.Duration)
+	G := make(map[string]map[int]float64) // km per gear
+	gear := GearTotals(db)
+	for _, g := range gear {
+		G[g.Name] = make(map[int]float64)
+	}
 	y0, y1 := 3000, 0
 	for i := 0; i < db.Len(); i++ {
 		h := db.Head(i)
-		y := unix(h.Start).Year()
+		y := h.Local().Year()
 		if y < y0 {
 			y0 = y
 		}
@@ -149,23 +359,50 @@ func Years(db DB) {
 			y1 = y
 		}
 		H[y] += time.Duration(int64(h.Seconds)) * time.Second
-		if h.Type == 1 {
-			R[y] += float64(h.Meters) / 1000
-		} else if h.Type == 2 {
-			B[y] += float64(h.Meters) / 1000
+		k := sportOf(h.Type)
+		if K[k] == nil {
+			K[k] = make(map[int]float64)
+		}
+		K[k][y] += float64(h.Meters) / 1000
+		if g := G[GearOf(db, h)]; g != nil {
+			g[y] += float64(h.Meters) / 1000
+		}
+	}
+	fmt.Printf("year")
+	for k := range K {
+		if K[k] != nil {
+			fmt.Printf(" %c/km", sports[k].Letter)
 		}
 	}
-	fmt.Printf("year R/km B/km H\n")
+	fmt.Printf(" H")
+	for _, g := range gear {
+		fmt.Printf(" %s", g.Name)
+	}
+	fmt.Println()
 	for y := y0; y <= y1; y++ {
-		fmt.Printf("%d %4.0f %4.0f %3d\n", y, R[y], B[y], H[y]/time.Hour)
+		fmt.Printf("%d", y)
+		for k := range K {
+			if K[k] != nil {
+				fmt.Printf(" %4.0f", K[k][y])
+			}
+		}
+		fmt.Printf(" %3d", H[y]/time.Hour)
+		for _, g := range gear {
+			fmt.Printf(" %*.0f", len(g.Name), G[g.Name][y])
+		}
+		fmt.Println()
 	}
+	GearWarnings(os.Stderr, db)
 }
 
-func OpenDB(dir string) (DiskDB, error) {
-	d := DiskDB{dir: dir}
+func OpenDB(dir string) (*DiskDB, error) {
+	d := &DiskDB{dir: dir, cells: new(Cells)}
+	if e := d.recover(); e != nil {
+		return nil, e
+	}
 	b, e := ioutil.ReadFile(d.indexpath())
 	if e != nil {
-		return DiskDB{}, e
+		return nil, e
 	}
 	s := bufio.NewScanner(bytes.NewReader(b))
 	line := 0
@@ -177,18 +414,107 @@ func OpenDB(dir string) (DiskDB, error) {
 		}
 		h, e := ParseHeader(t)
 		if e != nil {
-			return DiskDB{}, fmt.Errorf("%s:%d: %s", d.indexpath(), line, e)
+			return nil, fmt.Errorf("%s:%d: %s", d.indexpath(), line, e)
 		}
 		d.index = append(d.index, h)
 	}
+	if sort.SliceIsSorted(d.index, func(i, j int) bool { return d.index[i].Start < d.index[j].Start }) == false {
+		sort.SliceStable(d.index, func(i, j int) bool { return d.index[i].Start < d.index[j].Start })
+		t := d.begin()
+		if e := t.index(d.index); e != nil {
+			t.abort()
+			return nil, e
+		}
+		if e := t.commit(); e != nil {
+			return nil, e
+		}
+		fmt.Fprintln(os.Stderr, "sorted", d.indexpath())
+	}
 	b, e = ioutil.ReadFile(d.racepath())
-	fatal(e)
+	if e != nil && os.IsNotExist(e) == false {
+		return nil, e
+	}
 	r, e := ReadRaces(bytes.NewReader(b))
-	fatal(e)
+	if e != nil {
+		return nil, fmt.Errorf("%s: %s", d.racepath(), e)
+	}
 	d.races = r
+	d.link()
+	d.meta, e = openMeta(d.metapath())
+	if e != nil {
+		return nil, e
+	}
+	d.gear, e = openGear(d.gearpath())
+	if e != nil {
+		return nil, e
+	}
 	return d, nil
 }
 
+// link sets the activity ids of races. A race links to the activity
+// with the closest local start time within an hour.
+func (d *DiskDB) link() {
+	const maxZone = 14 * 3600
+	for k, r := range d.races {
+		d.races[k].Id = 0
+		i := sort.Search(len(d.index), func(i int) bool { return d.index[i].Start >= r.Start-3600-maxZone })
+		best := int64(3601)
+		for ; i < len(d.index) && d.index[i].Start <= r.Start+3600+maxZone; i++ {
+			if dt := abs64(d.index[i].wall() - r.Start); dt < best {
+				best, d.races[k].Id = dt, d.index[i].Start
+			}
+		}
+	}
+}
+func abs64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// AddRace validates r and adds it to race.txt.
+func (d *DiskDB) AddRace(r Race) error {
+	if e := r.Check(); e != nil {
+		return e
+	}
+	for _, x := range d.races {
+		if x.Start == r.Start {
+			return fmt.Errorf("race: %d: exists", r.Start)
+		}
+	}
+	i := sort.Search(len(d.races), func(i int) bool { return d.races[i].Start > r.Start })
+	races := append(append(append([]Race{}, d.races[:i]...), r), d.races[i:]...)
+	return d.setRaces(races)
+}
+
+// RemoveRace removes the race that starts at start.
+func (d *DiskDB) RemoveRace(start int64) error {
+	var races []Race
+	for _, r := range d.races {
+		if r.Start != start {
+			races = append(races, r)
+		}
+	}
+	if len(races) == len(d.races) {
+		return fmt.Errorf("race not found: %d", start)
+	}
+	return d.setRaces(races)
+}
+func (d *DiskDB) setRaces(races []Race) error {
+	t := d.begin()
+	if e := t.races(races); e != nil {
+		t.abort()
+		return e
+	}
+	if e := t.commit(); e != nil {
+		return e
+	}
+	d.races = races
+	d.link()
+	return nil
+}
+
 type SingleFile File
 
 func (s SingleFile) Len() int                 { return 1 }
@@ -196,6 +522,13 @@ func (s SingleFile) Head(i int) Header        { return s.Header }
 func (s SingleFile) File(i int) (File, error) { return File(s), nil }
 func (s SingleFile) Races() []Race            { return nil }
 
+type Files []File // e.g. the sessions of a multisport fit file
+
+func (s Files) Len() int                 { return len(s) }
+func (s Files) Head(i int) Header        { return s[i].Header }
+func (s Files) File(i int) (File, error) { return s[i], nil }
+func (s Files) Races() []Race            { return nil }
+
 func Filter(d DB, g func(f File) bool) SubDB {
 	s := SubDB{d: d, m: make(map[int]int)}
 	k := 0
@@ -221,7 +554,7 @@ func FilterH(d DB, g func(h Header) bool) SubDB {
 }
 func DateFilter(start, end int64) func(h Header) bool {
 	return func(h Header) bool {
-		return h.Start >= start && h.Start <= end
+		return h.wall() >= start && h.wall() <= end // local days
 	}
 }
 
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AddDiary adds a training log exported from a spreadsheet as header-only entries, one per line:
+//
+//	date,sport,distance,time,climb,title,notes
+//	2023-06-07,R,10.2,0:39:02,120,hills,felt good
+//
+// The first line may name the columns, climb, title and notes are optional. The separator is , or ;
+// A date may have a time (2023-06-07 08:00), otherwise it starts at noon and further entries of that day a minute later.
+// Distance is in km, time is h:mm:ss, mm:ss or a duration (39m2s).
+// Nothing is added if a line is invalid, all errors are returned with their line number.
+func (d *DiskDB) AddDiary(r io.Reader, name string) (n int, errs []error) {
+	col := []string{"date", "sport", "distance", "time", "climb", "title", "notes"}
+	var fs []File
+	meta := make(map[int64]Meta)
+	day := make(map[string]int) // entries without time
+	seen := make(map[int64]bool)
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		t := strings.TrimSpace(strings.TrimPrefix(s.Text(), "\ufeff"))
+		if t == "" {
+			continue
+		}
+		c := csv.NewReader(strings.NewReader(t))
+		c.TrimLeadingSpace = true
+		if strings.Count(t, ";") > strings.Count(t, ",") {
+			c.Comma = ';'
+		}
+		v, e := c.Read()
+		if e == nil && line == 1 && diaryColumn(v[0]) != "" {
+			col, e = diaryHeader(v)
+			if e != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: %s", name, line, e))
+				return 0, errs
+			}
+			continue
+		}
+		var f File
+		var m Meta
+		if e == nil {
+			f, m, e = diaryLine(col, v, day)
+		}
+		if e == nil && (d.find(f.Start) >= 0 || seen[f.Start]) {
+			e = fmt.Errorf("%s: exists", time.Unix(f.Start, 0).In(zone).Format("2006-01-02 15:04:05"))
+		}
+		if e != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %s", name, line, e))
+			continue
+		}
+		fs = append(fs, f)
+		seen[f.Start] = true
+		if m.Empty() == false {
+			meta[f.Start] = m
+		}
+	}
+	if e := s.Err(); e != nil {
+		errs = append(errs, e)
+	}
+	if len(errs) > 0 {
+		return 0, errs
+	}
+	if e := d.AddMeta(fs, meta); e != nil {
+		return 0, []error{e}
+	}
+	return len(fs), nil
+}
+
+func diaryColumn(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "date", "day":
+		return "date"
+	case "sport", "type", "activity":
+		return "sport"
+	case "distance", "km", "dist":
+		return "distance"
+	case "time", "duration":
+		return "time"
+	case "climb", "elevation", "ascent", "gain":
+		return "climb"
+	case "title", "name":
+		return "title"
+	case "notes", "note", "comment":
+		return "notes"
+	}
+	return ""
+}
+func diaryHeader(v []string) ([]string, error) {
+	col := make([]string, len(v))
+	for i, s := range v {
+		col[i] = diaryColumn(s)
+	}
+	for _, c := range []string{"date", "sport", "distance", "time"} {
+		if diaryIndex(col, c) < 0 {
+			return nil, fmt.Errorf("missing column: %s", c)
+		}
+	}
+	return col, nil
+}
+func diaryIndex(col []string, c string) int {
+	for i, s := range col {
+		if s == c {
+			return i
+		}
+	}
+	return -1
+}
+func diaryLine(col, v []string, day map[string]int) (f File, m Meta, e error) {
+	get := func(c string) string {
+		if i := diaryIndex(col, c); i >= 0 && i < len(v) {
+			return strings.TrimSpace(v[i])
+		}
+		return ""
+	}
+	for _, c := range []string{"date", "sport", "distance", "time"} {
+		if get(c) == "" {
+			return f, m, fmt.Errorf("missing %s", c)
+		}
+	}
+	t, dateonly, e := parseWhen(get("date"))
+	if e != nil {
+		return f, m, fmt.Errorf("date: %s", get("date"))
+	}
+	if dateonly {
+		k := t.Format("2006-01-02")
+		t = t.Add(12*time.Hour + time.Duration(day[k])*time.Minute)
+		day[k]++
+	}
+	typ, e := parseSport(get("sport"))
+	if e != nil {
+		return f, m, e
+	}
+	km, e := parseKm(get("distance"))
+	if e != nil {
+		return f, m, fmt.Errorf("distance: %s", get("distance"))
+	}
+	dur, e := parseDur(get("time"))
+	if e != nil {
+		return f, m, fmt.Errorf("time: %s", get("time"))
+	}
+	if e := m.set("climb", strings.Replace(get("climb"), ",", ".", 1)); e != nil {
+		return f, m, e
+	}
+	m.set("title", get("title"))
+	m.set("note", get("notes"))
+	f.Header = Header{Start: t.Unix(), Type: typ, Seconds: float32(dur.Seconds()), Meters: float32(1000 * km), Zone: noZone}
+	return f, m, nil
+}
+
+// parseWhen parses a local time 20230607T080000, 2023-06-07 08:00 or a date (2023-06-07, 2023.06.07, 07.06.2023).
+func parseWhen(s string) (t time.Time, dateonly bool, e error) {
+	for _, l := range []string{"20060102T150405", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04", "2006.01.02 15:04:05", "2006.01.02 15:04", "02.01.2006 15:04"} {
+		if t, e = time.ParseInLocation(l, s, zone); e == nil {
+			return t, false, nil
+		}
+	}
+	for _, l := range []string{"2006-01-02", "2006.01.02", "2006/01/02", "02.01.2006"} {
+		if t, e = time.ParseInLocation(l, s, zone); e == nil {
+			return t, true, nil
+		}
+	}
+	return t, false, e
+}
+
+// parseKm accepts 10.2, 10,2 and 10.2km.
+func parseKm(s string) (float64, error) {
+	km, e := strconv.ParseFloat(strings.Replace(strings.TrimSuffix(s, "km"), ",", ".", 1), 64)
+	if e == nil && (km < 0 || math.IsNaN(km) || math.IsInf(km, 0)) {
+		e = fmt.Errorf("km: %s", s)
+	}
+	return km, e
+}
+
+// parseDur accepts h:mm:ss, mm:ss and durations (39m2s).
+func parseDur(s string) (time.Duration, error) {
+	if v := strings.Split(s, ":"); len(v) == 2 || len(v) == 3 {
+		var d time.Duration
+		for _, x := range v {
+			n, e := strconv.ParseUint(x, 10, 32)
+			if e != nil {
+				return 0, e
+			}
+			d = 60*d + time.Duration(n)*time.Second
+		}
+		return d, nil
+	}
+	d, e := time.ParseDuration(s)
+	if e == nil && d < 0 {
+		e = fmt.Errorf("negative duration: %s", s)
+	}
+	return d, e
+}
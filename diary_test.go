@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDur(t *testing.T) {
+	for _, c := range []struct {
+		s string
+		d time.Duration
+	}{
+		{"0:39:02", 39*time.Minute + 2*time.Second},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second},
+		{"39:02", 39*time.Minute + 2*time.Second},
+		{"39m2s", 39*time.Minute + 2*time.Second},
+		{"3h", 3 * time.Hour},
+		{"", -1},
+		{"1:2:3:4", -1},
+		{"a:02", -1},
+		{"-1:02", -1},
+		{"-3h", -1},
+	} {
+		d, e := parseDur(c.s)
+		if c.d < 0 && e == nil {
+			t.Errorf("%q: expected an error (got %v)", c.s, d)
+		} else if c.d >= 0 && (e != nil || d != c.d) {
+			t.Errorf("%q: got %v %v, expected %v", c.s, d, e, c.d)
+		}
+	}
+}
+
+func TestParseWhen(t *testing.T) {
+	zone = time.UTC
+	for _, c := range []struct {
+		s        string
+		t        string
+		dateonly bool
+	}{
+		{"20230607T080000", "2023-06-07 08:00:00", false},
+		{"2023-06-07 08:00", "2023-06-07 08:00:00", false},
+		{"2023-06-07T08:00:05", "2023-06-07 08:00:05", false},
+		{"07.06.2023 08:00", "2023-06-07 08:00:00", false},
+		{"2023-06-07", "2023-06-07 00:00:00", true},
+		{"2023.06.07", "2023-06-07 00:00:00", true},
+		{"2023/06/07", "2023-06-07 00:00:00", true},
+		{"07.06.2023", "2023-06-07 00:00:00", true},
+		{"2023-13-07", "", false},
+		{"yesterday", "", false},
+	} {
+		tm, dateonly, e := parseWhen(c.s)
+		if c.t == "" {
+			if e == nil {
+				t.Errorf("%q: expected an error (got %v)", c.s, tm)
+			}
+		} else if s := tm.Format("2006-01-02 15:04:05"); e != nil || s != c.t || dateonly != c.dateonly {
+			t.Errorf("%q: got %s %v %v, expected %s %v", c.s, s, dateonly, e, c.t, c.dateonly)
+		}
+	}
+}
+
+func TestParseKm(t *testing.T) {
+	for s, x := range map[string]float64{"10.2": 10.2, "10,2": 10.2, "10.2km": 10.2, "0": 0, "-1": -1, "NaN": -1, "x": -1} {
+		km, e := parseKm(s)
+		if x < 0 && e == nil || x >= 0 && (e != nil || km != x) {
+			t.Errorf("%q: got %v %v, expected %v", s, km, e, x)
+		}
+	}
+}
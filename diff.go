@@ -14,23 +14,27 @@ func fitDiff(db DB, dir string) {
 	for _, fi := range files {
 		if fi.IsDir() == false {
 			name := filepath.Join(dir, fi.Name())
-			f, e := ReadFit(name)
+			fs, e := ReadFit(name)
 			if e != nil {
 				fmt.Println(name, e)
 			}
 			fatal(e)
-			//fmt.Println(f.Start, f.Samples, name)
-			g, e := Find(db, f.Start)
-			if e != nil {
-				g, e = Find(db, f.Start+3600)
-				dh++
-			}
-			if e != nil {
-				fmt.Println(f.Start, f.Samples, name, e)
-			} else {
-				e = diffFile(name, f, g)
+			for _, f := range fs {
+				//fmt.Println(f.Start, f.Samples, name)
+				g, e := Find(db, f.Start)
+				if e != nil { // like has: older entries may be an hour off
+					dh++
+					if g, e = Find(db, f.Start+3600); e != nil {
+						g, e = Find(db, f.Start-3600)
+					}
+				}
 				if e != nil {
 					fmt.Println(f.Start, f.Samples, name, e)
+				} else {
+					e = diffFile(name, f, g)
+					if e != nil {
+						fmt.Println(f.Start, f.Samples, name, e)
+					}
 				}
 			}
 		}
@@ -39,10 +43,10 @@ func fitDiff(db DB, dir string) {
 }
 
 func diffFile(name string, f, g File) error {
-	if f.Start != g.Start && f.Start+3600 != g.Start {
+	if d := g.Start - f.Start; d != 0 && d != 3600 && d != -3600 {
 		return fmt.Errorf("%s: Start %v %v", name, f.Start, g.Start)
 	}
-	if f.Type != g.Type {
+	if f.Type != g.Type && f.Type&0xff != g.Type { // older entries have no sub-sport
 		return fmt.Errorf("%s: Type %v %v", name, f.Type, g.Type)
 	}
 	if math.Abs(float64(round(f.Seconds)-g.Seconds)) > 1 {
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Merge joins the entries a and b, e.g. two recordings of a watch that rebooted.
+// Time and Dist of the later one continue the earlier one, the header adds seconds and meters.
+// The merged entry keeps the id, sport and metadata of the earlier one.
+func (d *DiskDB) Merge(a, b int64) (File, error) {
+	if a > b {
+		a, b = b, a
+	}
+	i, j := d.find(a), d.find(b)
+	if i < 0 || j < 0 || a == b {
+		return File{}, fmt.Errorf("merge: need two ids in the index: %d %d", a, b)
+	}
+	x, e := d.File(i)
+	if e != nil {
+		return File{}, e
+	}
+	y, e := d.File(j)
+	if e != nil {
+		return File{}, e
+	}
+	f, e := merge(x, y)
+	if e != nil {
+		return File{}, e
+	}
+	var index []Header
+	for _, h := range d.index {
+		if h.Start == a {
+			index = append(index, f.Header)
+		} else if h.Start != b {
+			index = append(index, h)
+		}
+	}
+	meta := d.meta
+	t := d.begin()
+	e = do(t.put(f), t.index(index))
+	e = do(e, t.cells(a, b))
+	if _, o := d.meta[b]; o {
+		meta = make(map[int64]Meta)
+		for k, m := range d.meta {
+			if k != b {
+				meta[k] = m
+			}
+		}
+		if _, o := meta[a]; !o {
+			meta[a] = d.meta[b]
+		}
+		e = do(e, t.metadata(meta))
+	}
+	if e != nil {
+		t.abort()
+		return File{}, e
+	}
+	t.rm(b)
+	if e := t.commit(); e != nil {
+		return File{}, e
+	}
+	d.index, d.meta = index, meta
+	d.link()
+	d.cells.m = nil
+	return f, nil
+}
+func merge(a, b File) (File, error) {
+	if a.Samples == 0 || b.Samples == 0 {
+		return File{}, fmt.Errorf("merge: entries without samples")
+	}
+	dt := float32(b.Start - a.Start)
+	if last := a.Time[a.Samples-1]; dt < last {
+		return File{}, fmt.Errorf("merge: %d starts before %d ends", b.Start, a.Start)
+	}
+	dd := a.Dist[a.Samples-1]
+	f := File{Header: a.Header}
+	f.Seconds, f.Meters, f.Samples = a.Seconds+b.Seconds, a.Meters+b.Meters, a.Samples+b.Samples
+	f.alloc()
+	f.allocExtra(a.extra() | b.extra())
+	n := copy(f.Time, a.Time)
+	for k, t := range b.Time {
+		f.Time[n+k] = t + dt
+		f.Dist[n+k] = b.Dist[k] + dd
+	}
+	copy(f.Dist, a.Dist)
+	copy(f.Alt, a.Alt)
+	copy(f.Alt[n:], b.Alt)
+	copy(f.Lat, a.Lat)
+	copy(f.Lat[n:], b.Lat)
+	copy(f.Lon, a.Lon)
+	copy(f.Lon[n:], b.Lon)
+	u16 := func(r, x, y []uint16) { // a column that is missing in one file is invalid there
+		for k := range r {
+			r[k] = invalidU16
+		}
+		if r != nil {
+			copy(r, x)
+			copy(r[n:], y)
+		}
+	}
+	u16(f.Hr, a.Hr, b.Hr)
+	u16(f.Cad, a.Cad, b.Cad)
+	u16(f.Pow, a.Pow, b.Pow)
+	if f.Speed != nil {
+		for k := range f.Speed {
+			f.Speed[k] = float32(math.NaN())
+		}
+		copy(f.Speed, a.Speed)
+		copy(f.Speed[n:], b.Speed)
+	}
+	if f.Temp != nil {
+		for k := range f.Temp {
+			f.Temp[k] = invalidI16
+		}
+		copy(f.Temp, a.Temp)
+		copy(f.Temp[n:], b.Temp)
+	}
+	f.Laps = append([]Lap{}, a.Laps...)
+	for _, l := range b.Laps {
+		l.Start += dt
+		f.Laps = append(f.Laps, l)
+	}
+	return f, nil
+}
+
+// Split cuts entry id at sec seconds after its start into two entries, it reverts Merge.
+// The second one starts at its first sample, Time and Dist continue from the last sample of the first one.
+// Seconds and Meters of the header are divided between them.
+// Metadata stays with the first part, the second part keeps the gear.
+func (d *DiskDB) Split(id int64, sec float64) (File, File, error) {
+	i := d.find(id)
+	if i < 0 {
+		return File{}, File{}, fmt.Errorf("id not found: %d", id)
+	}
+	f, e := d.File(i)
+	if e != nil {
+		return File{}, File{}, e
+	}
+	a, b, e := split(f, sec)
+	if e != nil {
+		return File{}, File{}, e
+	}
+	if d.find(b.Start) >= 0 {
+		return File{}, File{}, fmt.Errorf("split: %d: file already exists in index", b.Start)
+	}
+	index := append([]Header{}, d.index...)
+	index[i] = a.Header
+	k := sort.Search(len(index), func(k int) bool { return index[k].Start > b.Start })
+	index = append(append(append([]Header{}, index[:k]...), b.Header), index[k:]...)
+	meta := d.meta
+	t := d.begin()
+	e = do(t.put(a), t.put(b))
+	e = do(e, t.index(index))
+	e = do(e, t.cells(id))
+	if g := d.meta[id].Gear; g != "" {
+		meta = make(map[int64]Meta)
+		for k, m := range d.meta {
+			meta[k] = m
+		}
+		meta[b.Start] = Meta{Gear: g}
+		e = do(e, t.metadata(meta))
+	}
+	if e != nil {
+		t.abort()
+		return File{}, File{}, e
+	}
+	if e := t.commit(); e != nil {
+		return File{}, File{}, e
+	}
+	d.index, d.meta = index, meta
+	d.link()
+	d.cells.m = nil
+	return a, b, nil
+}
+func split(f File, sec float64) (a, b File, e error) {
+	n := int(f.Samples)
+	k := sort.Search(n, func(k int) bool { return float64(f.Time[k]) >= sec })
+	if k == 0 || k == n {
+		return a, b, fmt.Errorf("split: %gs is not inside the track (0..%gs)", sec, float64(f.Time[n-1]))
+	}
+	off := int64(f.Time[k])
+	if off == 0 {
+		return a, b, fmt.Errorf("split: %gs is too close to the start", sec)
+	}
+	part := func(i, j int, start int64) (p File) {
+		p.Header = f.Header
+		p.Start, p.Samples = start, uint64(j-i)
+		t, m := float32(start-f.Start), float32(0)
+		if i > 0 {
+			m = f.Dist[i-1] // the distance to the first sample belongs to the second part
+		}
+		p.Time, p.Dist = append([]float32{}, f.Time[i:j]...), append([]float32{}, f.Dist[i:j]...)
+		for x := range p.Time {
+			p.Time[x] -= t
+			p.Dist[x] -= m
+		}
+		p.Alt, p.Lat, p.Lon = f.Alt[i:j], f.Lat[i:j], f.Lon[i:j]
+		if f.Hr != nil {
+			p.Hr = f.Hr[i:j]
+		}
+		if f.Cad != nil {
+			p.Cad = f.Cad[i:j]
+		}
+		if f.Pow != nil {
+			p.Pow = f.Pow[i:j]
+		}
+		if f.Speed != nil {
+			p.Speed = f.Speed[i:j]
+		}
+		if f.Temp != nil {
+			p.Temp = f.Temp[i:j]
+		}
+		return p
+	}
+	a, b = part(0, k, f.Start), part(k, n, f.Start+off)
+
+	// Seconds is the timer time (as in merge): from the laps, or the elapsed time of the first part.
+	// A lap that spans the cut is split at it.
+	c, t := f.Time[k], float32(off)
+	dist := func(s float32) float32 { return f.Dist[sort.Search(n-1, func(i int) bool { return f.Time[i] >= s })] }
+	a.Seconds = f.Seconds
+	if f.Time[k-1] < a.Seconds {
+		a.Seconds = f.Time[k-1]
+	}
+	if len(f.Laps) > 0 {
+		a.Seconds = 0
+	}
+	for _, l := range f.Laps {
+		switch {
+		case l.Start >= c:
+			l.Start -= t
+			b.Laps = append(b.Laps, l)
+		case l.Start+l.Seconds <= c:
+			a.Laps = append(a.Laps, l)
+			a.Seconds += l.Seconds
+		default:
+			x, y := l, l
+			x.Seconds, x.Meters = c-l.Start, f.Dist[k-1]-dist(l.Start)
+			if x.Meters < 0 || x.Meters > l.Meters {
+				x.Meters = l.Meters * x.Seconds / l.Seconds
+			}
+			y.Start, y.Seconds, y.Meters = c-t, l.Seconds-x.Seconds, l.Meters-x.Meters
+			a.Laps, b.Laps = append(a.Laps, x), append(b.Laps, y)
+			a.Seconds += x.Seconds
+		}
+	}
+	b.Seconds = f.Seconds - a.Seconds
+	a.Meters = f.Dist[k-1]
+	if a.Meters > f.Meters || a.Meters != a.Meters {
+		a.Meters = f.Meters * float32(k) / float32(n)
+	}
+	b.Meters = f.Meters - a.Meters
+	if b.Seconds < 0 {
+		b.Seconds = 0
+	}
+	return a, b, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeSplit(t *testing.T) {
+	a, b := testFile(), testFile()
+	b.Start += 100
+	b.Seconds, b.Meters = 2.5, 12
+	b.Laps = []Lap{{0, 2.5, 12, 7}}
+	m, e := merge(a, b)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if m.Seconds != 5.5 || m.Meters != 24.5 || m.Samples != 8 || len(m.Laps) != 3 {
+		t.Fatalf("merge: %+v", m.Header)
+	}
+	x, y, e := split(m, 100)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(x, a) {
+		t.Fatalf("got %+v\nexpected %+v", x, a)
+	}
+	if !reflect.DeepEqual(y, b) {
+		t.Fatalf("got %+v\nexpected %+v", y, b)
+	}
+}
+
+func TestSplitLap(t *testing.T) {
+	f := testFile() // laps 0..2, 2..3
+	a, b, e := split(f, 1)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if a.Seconds+b.Seconds != f.Seconds || a.Meters+b.Meters != f.Meters {
+		t.Fatalf("totals: %+v %+v", a.Header, b.Header)
+	}
+	la, lb := []Lap{{0, 1, 0, 0}}, []Lap{{0, 1, 8.5, 0}, {1, 1, 4, 7}}
+	if !reflect.DeepEqual(a.Laps, la) || !reflect.DeepEqual(b.Laps, lb) {
+		t.Fatalf("laps: %v %v", a.Laps, b.Laps)
+	}
+	if _, _, e := split(f, 3.5); e == nil {
+		t.Fatal("split after the end: expected an error")
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -15,18 +14,31 @@ import (
 
 type File struct {
 	Header
-	Time []float32 // seconds
-	Dist []float32 // meters
-	Alt  []float32 // altitude (m)
-	Lat  []int32   // semicircles (invalid: 0x7FFFFFFF) (180 / math.Pow(2, 31))
-	Lon  []int32   // semicircles
+	Time  []float32 // seconds
+	Dist  []float32 // meters
+	Alt   []float32 // altitude (m)
+	Lat   []int32   // semicircles (invalid: 0x7FFFFFFF) (180 / math.Pow(2, 31))
+	Lon   []int32   // semicircles
+	Hr    []uint16  // heart rate (bpm) (optional: nil or Samples long, invalid: 0xFFFF)
+	Cad   []uint16  // cadence (rpm) (optional)
+	Pow   []uint16  // power (W) (optional)
+	Speed []float32 // m/s (optional, invalid: NaN)
+	Temp  []int16   // temperature (°C) (optional, invalid: 0x7FFF)
+	Laps  []Lap
+}
+type Lap struct {
+	Start   float32 // seconds since File.Start
+	Seconds float32 // timer duration
+	Meters  float32 // distance
+	Trigger uint8   // fit.LapTrigger 0(manual) 1(time) 2(distance) 3..6(position) 7(session end)
 }
 type Header struct {
 	Start   int64   // unix time (seconds)
-	Type    uint32  // type 1(run) 2(cycle)
+	Type    uint32  // FIT sport|sub-sport<<8: 1(run) 2(cycle) 5(swim) (see Sport)
 	Seconds float32 // total duration
 	Meters  float32 // total distance
 	Samples uint64  // number of samples
+	Zone    int32   // UTC offset of local time (seconds), noZone: configured zone
 }
 type Race struct {
 	Start  int64         // unix time (seconds)
@@ -34,6 +46,7 @@ type Race struct {
 	Time   time.Duration //
 	Result string        // "101/2048"
 	Name   string
+	Id     int64 // linked activity (0: none), not stored
 }
 
 func (f *File) alloc() {
@@ -44,6 +57,42 @@ func (f *File) alloc() {
 	(*f).Lat = make([]int32, samples)
 	(*f).Lon = make([]int32, samples)
 }
+func (f *File) allocExtra(mask uint32) {
+	n := f.Samples
+	if mask&hasHr != 0 {
+		(*f).Hr = make([]uint16, n)
+	}
+	if mask&hasCad != 0 {
+		(*f).Cad = make([]uint16, n)
+	}
+	if mask&hasPow != 0 {
+		(*f).Pow = make([]uint16, n)
+	}
+	if mask&hasSpeed != 0 {
+		(*f).Speed = make([]float32, n)
+	}
+	if mask&hasTemp != 0 {
+		(*f).Temp = make([]int16, n)
+	}
+}
+func (f File) extra() (mask uint32) { // optional columns that are present
+	if f.Hr != nil {
+		mask |= hasHr
+	}
+	if f.Cad != nil {
+		mask |= hasCad
+	}
+	if f.Pow != nil {
+		mask |= hasPow
+	}
+	if f.Speed != nil {
+		mask |= hasSpeed
+	}
+	if f.Temp != nil {
+		mask |= hasTemp
+	}
+	return mask
+}
 func (f File) Empty() bool { return f.Start == 0 }
 
 func rad(deg float64) float64 { return math.Pi * deg / 180.0 }
@@ -54,11 +103,17 @@ func Deg(s int32) float64 {
 	}
 	return float64(s) * 8.381903171539307e-08 // * 180/2^31
 }
+func Semis(deg float64) int32 {
+	if math.IsNaN(deg) {
+		return invalidSemis
+	}
+	return int32(math.Pow(2, 31) * deg / 180.0)
+}
 func ParseHeader(s string) (h Header, e error) {
 	v := strings.Fields(s)
 	err := func(s string) error { return fmt.Errorf("index: %s", s) }
-	if len(v) != 5 {
-		return h, err(fmt.Sprintf("expected %d fields (not %d)", 5, len(v)))
+	if len(v) != 5 && len(v) != 6 {
+		return h, err(fmt.Sprintf("expected 5 or 6 fields (not %d)", len(v)))
 	}
 	h.Start, e = strconv.ParseInt(v[0], 10, 64)
 	if e != nil {
@@ -85,13 +140,25 @@ func ParseHeader(s string) (h Header, e error) {
 	if e != nil {
 		return h, err("samples")
 	}
+	h.Zone = noZone
+	if len(v) == 6 {
+		z, e := strconv.ParseInt(v[5], 10, 32)
+		if e != nil || z == int64(noZone) {
+			return h, err("zone")
+		}
+		h.Zone = int32(z)
+	}
 	return h, nil
 }
 func (h Header) Indexline() string { // entry(line) in db/index.txt
-	return fmt.Sprint(h.Start, h.Type, h.Seconds, h.Meters, h.Samples)
+	s := fmt.Sprint(h.Start, h.Type, h.Seconds, h.Meters, h.Samples)
+	if h.Zone != noZone {
+		s += fmt.Sprint(" ", h.Zone)
+	}
+	return s
 }
 func (h Header) String() string { // list output
-	date := unix(h.Start).Format("2006.01.02T15:04:05")
+	date := h.Local().Format("2006.01.02T15:04:05")
 	hh := int(h.Seconds / 3600)
 	mm := int(h.Seconds/60) - hh*60
 	ss := int(h.Seconds) - hh*3600 - mm*60
@@ -104,28 +171,52 @@ func ReadRaces(r io.Reader) (races []Race, e error) {
 		if len(t) == 0 {
 			continue
 		}
-		err := func(s string) error { return fmt.Errorf("race: %s: %s", t, s) }
-		v := strings.Fields(t)
-		if len(v) < 5 {
-			return nil, err("fields")
-		}
-		var r Race
-		if time, e := time.Parse("20060102T150405", v[0]); e != nil {
-			return nil, err("parse start")
-		} else {
-			r.Start = time.Unix()
-		}
-		r.Type = v[1]
-		r.Time, e = time.ParseDuration(v[2])
+		r, e := ParseRace(t)
 		if e != nil {
-			return nil, err("parse time")
+			return nil, e
 		}
-		r.Result = v[3]
-		r.Name = strings.Join(v[4:], " ")
 		races = append(races, r)
 	}
 	return races, nil
 }
+func ParseRace(t string) (r Race, e error) { // line in db/race.txt
+	err := func(s string) error { return fmt.Errorf("race: %s: %s", t, s) }
+	v := strings.Fields(t)
+	if len(v) < 5 {
+		return r, err("fields")
+	}
+	if time, e := time.Parse("20060102T150405", v[0]); e != nil {
+		return r, err("parse start")
+	} else {
+		r.Start = time.Unix()
+	}
+	r.Type = v[1]
+	r.Time, e = time.ParseDuration(v[2])
+	if e != nil {
+		return r, err("parse time")
+	}
+	r.Result = v[3]
+	r.Name = strings.Join(v[4:], " ")
+	return r, nil
+}
+
+// Check validates a new race: time must not be negative and result is place/total.
+func (r Race) Check() error {
+	err := func(s string) error { return fmt.Errorf("race: %s: %s", r.String(), s) }
+	if r.Time < 0 {
+		return err("negative time")
+	}
+	v := strings.Split(r.Result, "/")
+	if len(v) != 2 {
+		return err("result is not place/total")
+	}
+	place, e1 := strconv.Atoi(v[0])
+	total, e2 := strconv.Atoi(v[1])
+	if e1 != nil || e2 != nil || place < 0 || place > total {
+		return err("result is not place/total")
+	}
+	return nil
+}
 func (r Race) String() string {
 	if r.Type == "" {
 		r.Type = "-"
@@ -136,30 +227,6 @@ func (r Race) String() string {
 	start := unix(r.Start).Format("20060102T150405")
 	return fmt.Sprintf("%s %s %v %s %s", start, r.Type, r.Time, r.Result, r.Name)
 }
-func Decode(b []byte) (File, error) {
-	r := bytes.NewReader(b)
-	var f File
-	if e := binary.Read(r, le, &f.Header); e != nil {
-		return f, e
-	}
-	f.alloc()
-	var e error
-	e = do(e, binary.Read(r, le, f.Time))
-	e = do(e, binary.Read(r, le, f.Dist))
-	e = do(e, binary.Read(r, le, f.Alt))
-	e = do(e, binary.Read(r, le, f.Lat))
-	e = do(e, binary.Read(r, le, f.Lon))
-	return f, e
-}
-func (f File) Encode(w io.Writer) (e error) {
-	e = do(e, binary.Write(w, le, f.Header))
-	e = do(e, binary.Write(w, le, f.Time))
-	e = do(e, binary.Write(w, le, f.Dist))
-	e = do(e, binary.Write(w, le, f.Alt))
-	e = do(e, binary.Write(w, le, f.Lat))
-	e = do(e, binary.Write(w, le, f.Lon))
-	return e
-}
 func do(a, b error) error {
 	if a != nil {
 		return a
@@ -168,32 +235,64 @@ func do(a, b error) error {
 }
 
 func (f File) Table(w io.Writer) {
-	fmt.Fprintf(w, "Start:   %s (%d)\n", unix(f.Start).Format("2006.01.02T15:04:05"), f.Start)
-	fmt.Fprintf(w, "Type:    %c\n", sport(f.Type))
+	fmt.Fprintf(w, "Start:   %s (%d)\n", f.Local().Format("2006.01.02T15:04:05-07:00"), f.Start)
+	fmt.Fprintf(w, "Type:    %c %s\n", sport(f.Type), sportName(f.Type))
 	fmt.Fprintf(w, "Seconds: %v (%s)\n", f.Seconds, time.Duration(f.Seconds)*time.Second)
 	fmt.Fprintf(w, "Meters:  %v\n", f.Meters)
 	tw := tabwriter.NewWriter(w, 2, 0, 2, ' ', 0)
-	fmt.Fprintf(tw, "#\tTime\tDist\tAlt\tLat\tLon\n")
+	if len(f.Laps) > 0 {
+		fmt.Fprintf(tw, "Lap\tStart\tSeconds\tMeters\tTrigger\n")
+		for i, l := range f.Laps {
+			fmt.Fprintf(tw, "%d\t%v\t%v\t%v\t%s\n", 1+i, l.Start, time.Duration(l.Seconds)*time.Second, l.Meters, l.TriggerName())
+		}
+		tw.Flush()
+	}
+	fmt.Fprintf(tw, "#\tTime\tDist\tAlt\tLat\tLon")
+	mask := f.extra()
+	for i, s := range []string{"Hr", "Cad", "Pow", "Speed", "Temp"} {
+		if mask&(1<<i) != 0 {
+			fmt.Fprintf(tw, "\t%s", s)
+		}
+	}
+	fmt.Fprintln(tw)
+	u16 := func(x []uint16, i int) {
+		if x != nil && x[i] == invalidU16 {
+			fmt.Fprintf(tw, "\t-")
+		} else if x != nil {
+			fmt.Fprintf(tw, "\t%d", x[i])
+		}
+	}
 	for i := 0; i < int(f.Samples); i++ {
-		fmt.Fprintf(tw, "%d\t%v\t%v\t%v\t%.6f\t%.6f\n", i, f.Time[i], f.Dist[i], f.Alt[i], Deg(f.Lat[i]), Deg(f.Lon[i]))
+		fmt.Fprintf(tw, "%d\t%v\t%v\t%v\t%.6f\t%.6f", i, f.Time[i], f.Dist[i], f.Alt[i], Deg(f.Lat[i]), Deg(f.Lon[i]))
+		u16(f.Hr, i)
+		u16(f.Cad, i)
+		u16(f.Pow, i)
+		if f.Speed != nil {
+			fmt.Fprintf(tw, "\t%.3f", f.Speed[i])
+		}
+		if f.Temp != nil && f.Temp[i] == invalidI16 {
+			fmt.Fprintf(tw, "\t-")
+		} else if f.Temp != nil {
+			fmt.Fprintf(tw, "\t%d", f.Temp[i])
+		}
+		fmt.Fprintln(tw)
 	}
 	tw.Flush()
 }
-func sport(x uint32) (r byte) {
-	r = '?'
-	switch x {
-	case 1:
-		r = 'R'
-	case 2:
-		r = 'B'
-	case 5:
-		r = 'S'
-	}
-	return r
-}
 
 const (
-	invalidSemis int32 = 0x7FFFFFFF
+	invalidSemis int32  = 0x7FFFFFFF
+	invalidU16   uint16 = 0xFFFF
+	invalidI16   int16  = 0x7FFF
+)
+
+// optional columns
+const (
+	hasHr uint32 = 1 << iota
+	hasCad
+	hasPow
+	hasSpeed
+	hasTemp
 )
 
 var le = binary.LittleEndian
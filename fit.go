@@ -4,18 +4,23 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
-	"time"
+	"math"
+	"sort"
 
 	"github.com/tormoder/fit"
 )
 
-func ReadFit(file string) (f File, e error) {
-	var b []byte
-	b, e = ioutil.ReadFile(file)
+// ReadFit returns one file per session, e.g. swim, transition, bike and run of a triathlon.
+// A session has the records and laps from its start time to the start of the next one.
+// Its id is the session start time (the first record without one), earlier records have negative times.
+func ReadFit(file string) ([]File, error) {
+	b, e := ioutil.ReadFile(file)
 	if e != nil {
-		return
+		return nil, e
 	}
-
+	return readFit(b)
+}
+func readFit(b []byte) (fs []File, e error) {
 	var t *fit.File
 	t, e = fit.Decode(bytes.NewReader(b))
 	if e != nil {
@@ -33,34 +38,54 @@ func ReadFit(file string) (f File, e error) {
 
 	rec := a.Records
 	if len(rec) < 1 {
-		return f, fmt.Errorf("file has no records")
+		return nil, fmt.Errorf("file has no records")
 	}
 	ses := a.Sessions
-	var start time.Time
-	var sport uint32
-	if len(ses) > 0 {
-		start = ses[0].StartTime
-		sport = uint32(ses[0].Sport)
+	if len(ses) == 0 {
+		ses = []*fit.SessionMsg{{StartTime: rec[0].Timestamp}}
 	}
-
-	var seconds, meters uint32
-	for _, s := range ses {
-		seconds += s.TotalTimerTime
-		meters += s.TotalDistance
+	zone := noZone
+	if a.Activity != nil {
+		zone = zoneOffset(a.Activity.Timestamp, a.Activity.LocalTimestamp)
 	}
-
-	samples := len(rec)
-	if start.IsZero() && samples > 0 {
+	laps := a.Laps
+	for i, s := range ses {
+		n := len(rec)
+		if i+1 < len(ses) {
+			end := ses[i+1].StartTime
+			n = sort.Search(len(rec), func(k int) bool { return !rec[k].Timestamp.Before(end) })
+		}
+		k := len(laps) // the last session has the remaining laps
+		if i+1 < len(ses) {
+			k = 0
+			for k < len(laps) && laps[k].StartTime.Before(ses[i+1].StartTime) {
+				k++
+			}
+		}
+		fs = append(fs, fitSession(s, rec[:n], laps[:k], zone))
+		rec, laps = rec[n:], laps[k:]
+	}
+	return fs, nil
+}
+func fitSession(s *fit.SessionMsg, rec []*fit.RecordMsg, laps []*fit.LapMsg, zone int32) (f File) {
+	start := s.StartTime // the id, records before it have negative time
+	if (start.IsZero() || fit.IsBaseTime(start)) && len(rec) > 0 {
 		start = rec[0].Timestamp
 	}
+	sport := uint32(s.Sport)
+	if sub := s.SubSport; sub != fit.SubSportInvalid {
+		sport |= uint32(sub) << 8
+	}
 	f.Header = Header{
 		Start:   start.Unix(),
 		Type:    sport,
-		Seconds: float32(seconds) / 1000.0,
-		Meters:  float32(meters) / 100.0,
-		Samples: uint64(samples),
+		Seconds: float32(s.TotalTimerTime) / 1000.0,
+		Meters:  float32(s.TotalDistance) / 100.0,
+		Samples: uint64(len(rec)),
+		Zone:    zone,
 	}
 	f.alloc()
+	f.allocExtra(hasHr | hasCad | hasPow | hasSpeed | hasTemp)
 
 	for i, r := range rec {
 		f.Time[i] = float32(r.Timestamp.Sub(start).Seconds())
@@ -68,6 +93,68 @@ func ReadFit(file string) (f File, e error) {
 		f.Alt[i] = float32(r.GetEnhancedAltitudeScaled())
 		f.Lat[i] = r.PositionLat.Semicircles()
 		f.Lon[i] = r.PositionLong.Semicircles()
+		f.Hr[i] = u8(r.HeartRate)
+		f.Cad[i] = u8(r.Cadence)
+		f.Pow[i] = r.Power
+		f.Speed[i] = float32(r.GetEnhancedSpeedScaled())
+		if math.IsNaN(float64(f.Speed[i])) {
+			f.Speed[i] = float32(r.GetSpeedScaled())
+		}
+		f.Temp[i] = invalidI16
+		if r.Temperature != 0x7F {
+			f.Temp[i] = int16(r.Temperature)
+		}
+	}
+	f.dropInvalid()
+
+	for _, l := range laps {
+		f.Laps = append(f.Laps, Lap{
+			Start:   float32(l.StartTime.Sub(start).Seconds()),
+			Seconds: float32(l.TotalTimerTime) / 1000.0,
+			Meters:  float32(l.TotalDistance) / 100.0,
+			Trigger: uint8(l.LapTrigger),
+		})
+	}
+	return f
+}
+func (l Lap) TriggerName() string { return fit.LapTrigger(l.Trigger).String() }
+func u8(x uint8) uint16 {
+	if x == 0xFF {
+		return invalidU16
+	}
+	return uint16(x)
+}
+
+// dropInvalid removes optional columns that have no valid sample.
+func (f *File) dropInvalid() {
+	valid16 := func(x []uint16) bool {
+		for _, v := range x {
+			if v != invalidU16 {
+				return true
+			}
+		}
+		return false
+	}
+	if !valid16(f.Hr) {
+		f.Hr = nil
+	}
+	if !valid16(f.Cad) {
+		f.Cad = nil
+	}
+	if !valid16(f.Pow) {
+		f.Pow = nil
+	}
+	speed, temp := false, false
+	for _, v := range f.Speed {
+		speed = speed || !math.IsNaN(float64(v))
+	}
+	for _, v := range f.Temp {
+		temp = temp || v != invalidI16
+	}
+	if !speed {
+		f.Speed = nil
+	}
+	if !temp {
+		f.Temp = nil
 	}
-	return f, nil
 }
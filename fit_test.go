@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/tormoder/fit"
+)
+
+// TestMultisport reads a run (with a record before its start) followed by a ride.
+func TestMultisport(t *testing.T) {
+	t0 := time.Unix(1439649908, 0)
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+	f, e := fit.NewFile(fit.FileTypeActivity, fit.NewHeader(fit.V20, false))
+	if e != nil {
+		t.Fatal(e)
+	}
+	a, _ := f.Activity()
+	for s := -2; s < 20; s++ {
+		r := fit.NewRecordMsg()
+		r.Timestamp, r.Distance, r.HeartRate = at(s), uint32(100*(s+2)), 120
+		a.Records = append(a.Records, r)
+	}
+	for i, s := range []struct {
+		start int
+		sport fit.Sport
+	}{{0, fit.SportRunning}, {10, fit.SportCycling}} {
+		m := fit.NewSessionMsg()
+		m.StartTime, m.Sport, m.SubSport, m.TotalTimerTime = at(s.start), s.sport, fit.SubSportGeneric, 10000
+		a.Sessions = append(a.Sessions, m)
+		for k := 0; k < 2-i; k++ { // laps: 2, 1
+			l := fit.NewLapMsg()
+			l.StartTime, l.TotalTimerTime = at(s.start+5*k), uint32(10000/(2-i))
+			a.Laps = append(a.Laps, l)
+		}
+	}
+	a.Activity = fit.NewActivityMsg()
+	a.Activity.Timestamp, a.Activity.LocalTimestamp = at(20), at(20).Add(2*time.Hour)
+	var b bytes.Buffer
+	if e := fit.Encode(&b, f, binary.LittleEndian); e != nil {
+		t.Fatal(e)
+	}
+
+	fs, e := readFit(b.Bytes())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(fs) != 2 {
+		t.Fatalf("%d sessions", len(fs))
+	}
+	for i, x := range []struct {
+		start   int64
+		typ     uint32
+		samples uint64
+		time0   float32
+		laps    int
+	}{{1439649908, 1, 12, -2, 2}, {1439649918, 2, 10, 0, 1}} {
+		g := fs[i]
+		if g.Start != x.start || g.Type != x.typ || g.Samples != x.samples || g.Time[0] != x.time0 || len(g.Laps) != x.laps || g.Zone != 7200 || g.Seconds != 10 {
+			t.Errorf("session %d: %+v time0=%v laps=%v", i, g.Header, g.Time[0], g.Laps)
+		}
+	}
+}
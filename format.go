@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Track file format (db/<id>), little endian:
+//	magic   [4]byte "kyd\x00"
+//	version uint32
+//	Header (version 1: without Zone)
+//	ncol    uint32
+//	ncol × column{Id, Enc uint16; Size uint32}
+//	column data in directory order
+//
+// Legacy files (version 0) are the bare Header followed by the five base columns.
+// They are detected by the missing "kyd\x00" magic (see Version).
+
+const formatVersion = 2
+
+var magic = [4]byte{'k', 'y', 'd', 0}
+
+type header1 struct { // Header of format version 1 and legacy files
+	Start   int64
+	Type    uint32
+	Seconds float32
+	Meters  float32
+	Samples uint64
+}
+
+func (h header1) header() Header {
+	return Header{Start: h.Start, Type: h.Type, Seconds: h.Seconds, Meters: h.Meters, Samples: h.Samples, Zone: noZone}
+}
+
+type column struct {
+	Id   uint16
+	Enc  uint16
+	Size uint32 // bytes
+}
+
+// column ids
+const (
+	colTime uint16 = 1 + iota
+	colDist
+	colAlt
+	colLat
+	colLon
+	colHr
+	colCad
+	colPow
+	colSpeed
+	colTemp
+	colLaps // []Lap (not per sample)
+)
+
+// column encodings
+const (
+	encRaw    uint16 = iota // little endian array
+	encVarint               // integers: varint deltas
+	encScaled               // floats: uint32 scale, varint deltas of round(scale*x)
+)
+
+// Version returns the format version of an encoded file (0: legacy).
+func Version(b []byte) uint32 {
+	if len(b) < 8 || bytes.Equal(b[:4], magic[:]) == false {
+		return 0
+	}
+	return le.Uint32(b[4:])
+}
+
+func Decode(b []byte) (File, error) {
+	v := Version(b)
+	if v == 0 {
+		return decodeLegacy(b)
+	} else if v > formatVersion {
+		return File{}, fmt.Errorf("unknown format version %d", v)
+	}
+	r := bytes.NewReader(b[8:])
+	var f File
+	if v == 1 {
+		var h header1
+		if e := binary.Read(r, le, &h); e != nil {
+			return f, e
+		}
+		f.Header = h.header()
+	} else if e := binary.Read(r, le, &f.Header); e != nil {
+		return f, e
+	}
+	var n uint32
+	if e := binary.Read(r, le, &n); e != nil {
+		return f, e
+	}
+	if int64(n) > int64(r.Len()) {
+		return f, fmt.Errorf("corrupt column directory")
+	}
+	dir := make([]column, n)
+	if e := binary.Read(r, le, dir); e != nil {
+		return f, e
+	}
+	for _, c := range dir {
+		if int64(c.Size) > int64(r.Len()) {
+			return f, io.ErrUnexpectedEOF
+		}
+		b := make([]byte, c.Size)
+		r.Read(b)
+		if e := f.decodeColumn(c, b); e != nil {
+			return f, e
+		}
+	}
+	for id := colTime; id < colLaps; id++ { // base columns are required, others may be missing
+		if k := colLen(f.column(id)); k != int(f.Samples) && (id <= colLon || k != 0) {
+			return f, fmt.Errorf("column %d: %d values for %d samples", id, k, f.Samples)
+		}
+	}
+	return f, nil
+}
+func colLen(p interface{}) int {
+	switch p := p.(type) {
+	case *[]float32:
+		return len(*p)
+	case *[]int32:
+		return len(*p)
+	case *[]uint16:
+		return len(*p)
+	case *[]int16:
+		return len(*p)
+	}
+	return 0
+}
+func (f *File) decodeColumn(c column, b []byte) error {
+	p := f.column(c.Id)
+	if p == nil {
+		return nil // unknown column from a newer writer
+	}
+	if c.Id == colLaps {
+		if c.Enc != encRaw || len(b)%13 != 0 {
+			return fmt.Errorf("laps: bad column")
+		}
+		f.Laps = make([]Lap, len(b)/13)
+		return binary.Read(bytes.NewReader(b), le, f.Laps)
+	}
+	switch c.Enc {
+	case encRaw:
+	case encVarint:
+		v, e := getDeltas(b, int(f.Samples))
+		if e != nil {
+			return fmt.Errorf("column %d: %s", c.Id, e)
+		}
+		return setInts(p, v)
+	case encScaled:
+		if len(b) < 4 {
+			return fmt.Errorf("column %d: short", c.Id)
+		}
+		v, e := getDeltas(b[4:], int(f.Samples))
+		if e != nil {
+			return fmt.Errorf("column %d: %s", c.Id, e)
+		}
+		return setScaled(p, v, le.Uint32(b))
+	default:
+		return fmt.Errorf("column %d: unknown encoding %d", c.Id, c.Enc)
+	}
+	n, size := int(f.Samples), 4
+	switch p.(type) {
+	case *[]uint16, *[]int16:
+		size = 2
+	}
+	if len(b) != n*size {
+		return fmt.Errorf("column %d: size %d does not match %d samples", c.Id, len(b), n)
+	}
+	switch p := p.(type) {
+	case *[]float32:
+		*p = make([]float32, n)
+	case *[]int32:
+		*p = make([]int32, n)
+	case *[]uint16:
+		*p = make([]uint16, n)
+	case *[]int16:
+		*p = make([]int16, n)
+	}
+	return binary.Read(bytes.NewReader(b), le, p)
+}
+
+// column returns a pointer to the column slice.
+func (f *File) column(id uint16) interface{} {
+	switch id {
+	case colTime:
+		return &f.Time
+	case colDist:
+		return &f.Dist
+	case colAlt:
+		return &f.Alt
+	case colLat:
+		return &f.Lat
+	case colLon:
+		return &f.Lon
+	case colHr:
+		return &f.Hr
+	case colCad:
+		return &f.Cad
+	case colPow:
+		return &f.Pow
+	case colSpeed:
+		return &f.Speed
+	case colTemp:
+		return &f.Temp
+	case colLaps:
+		return &f.Laps
+	}
+	return nil
+}
+func (f File) Encode(w io.Writer) (e error) {
+	var dir []column
+	var data bytes.Buffer
+	for id := colTime; id <= colLaps; id++ {
+		p := f.column(id)
+		if id > colLon && binary.Size(p) == 0 {
+			continue // optional column is not present
+		}
+		n := data.Len()
+		enc := encodeColumn(&data, id, p)
+		if enc == encRaw {
+			e = do(e, binary.Write(&data, le, p))
+		}
+		dir = append(dir, column{Id: id, Enc: enc, Size: uint32(data.Len() - n)})
+	}
+	e = do(e, binary.Write(w, le, magic))
+	e = do(e, binary.Write(w, le, uint32(formatVersion)))
+	e = do(e, binary.Write(w, le, f.Header))
+	e = do(e, binary.Write(w, le, uint32(len(dir))))
+	e = do(e, binary.Write(w, le, dir))
+	if e == nil {
+		_, e = data.WriteTo(w)
+	}
+	return e
+}
+
+// encodeColumn appends the compact encoding of integer columns and of float columns
+// that survive scaling (altitude is quantised to 0.1m). It returns encRaw without
+// writing if there is no compact form.
+func encodeColumn(b *bytes.Buffer, id uint16, p interface{}) uint16 {
+	if id == colLaps {
+		return encRaw
+	}
+	x, o := p.(*[]float32)
+	if o == false {
+		putDeltas(b, ints(p))
+		return encVarint
+	}
+	scales := []uint32{1, 10, 100, 1000}
+	if id == colAlt {
+		scales = []uint32{10}
+	}
+	for _, s := range scales {
+		if v, o := scaled(*x, s, id == colAlt); o {
+			binary.Write(b, le, s)
+			putDeltas(b, v)
+			return encScaled
+		}
+	}
+	return encRaw
+}
+
+// Scaled values are stored as v<<1|nan.
+func scaled(x []float32, s uint32, lossy bool) ([]int64, bool) {
+	r := make([]int64, len(x))
+	for i, f := range x {
+		if math.IsNaN(float64(f)) {
+			r[i] = 1
+			continue
+		}
+		u := math.Round(float64(f) * float64(s))
+		if math.Abs(u) > 1<<52 || (lossy == false && float32(u/float64(s)) != f) {
+			return nil, false
+		}
+		r[i] = int64(u) << 1
+	}
+	return r, true
+}
+func setScaled(p interface{}, v []int64, s uint32) error {
+	x, o := p.(*[]float32)
+	if o == false || s == 0 {
+		return fmt.Errorf("bad scaled column")
+	}
+	*x = make([]float32, len(v))
+	for i, u := range v {
+		if u&1 != 0 {
+			(*x)[i] = float32(math.NaN())
+		} else {
+			(*x)[i] = float32(float64(u>>1) / float64(s))
+		}
+	}
+	return nil
+}
+func ints(p interface{}) (r []int64) {
+	switch p := p.(type) {
+	case *[]int32:
+		for _, x := range *p {
+			r = append(r, int64(x))
+		}
+	case *[]uint16:
+		for _, x := range *p {
+			r = append(r, int64(x))
+		}
+	case *[]int16:
+		for _, x := range *p {
+			r = append(r, int64(x))
+		}
+	}
+	return r
+}
+func setInts(p interface{}, v []int64) error {
+	switch p := p.(type) {
+	case *[]int32:
+		*p = make([]int32, len(v))
+		for i, x := range v {
+			(*p)[i] = int32(x)
+		}
+	case *[]uint16:
+		*p = make([]uint16, len(v))
+		for i, x := range v {
+			(*p)[i] = uint16(x)
+		}
+	case *[]int16:
+		*p = make([]int16, len(v))
+		for i, x := range v {
+			(*p)[i] = int16(x)
+		}
+	default:
+		return fmt.Errorf("bad integer column")
+	}
+	return nil
+}
+func putDeltas(b *bytes.Buffer, v []int64) {
+	var buf [binary.MaxVarintLen64]byte
+	last := int64(0)
+	for _, x := range v {
+		b.Write(buf[:binary.PutVarint(buf[:], x-last)])
+		last = x
+	}
+}
+func getDeltas(b []byte, n int) ([]int64, error) {
+	if n > len(b) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	r := make([]int64, n)
+	last := int64(0)
+	for i := range r {
+		d, k := binary.Varint(b)
+		if k <= 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		b = b[k:]
+		last += d
+		r[i] = last
+	}
+	return r, nil
+}
+
+// decodeLegacy reads the unversioned format: Header, Time, Dist, Alt, Lat, Lon.
+func decodeLegacy(b []byte) (File, error) {
+	r := bytes.NewReader(b)
+	var f File
+	var h header1
+	if e := binary.Read(r, le, &h); e != nil {
+		return f, e
+	}
+	f.Header = h.header()
+	f.alloc()
+	var e error
+	e = do(e, binary.Read(r, le, f.Time))
+	e = do(e, binary.Read(r, le, f.Dist))
+	e = do(e, binary.Read(r, le, f.Alt))
+	e = do(e, binary.Read(r, le, f.Lat))
+	e = do(e, binary.Read(r, le, f.Lon))
+	return f, e
+}
+
+// Migrate rewrites all track files that are older than the current format version.
+func (d DiskDB) Migrate() (n int, e error) {
+	n, _, _, e = d.rewrite(func(b []byte, f File) bool { return Version(b) != formatVersion })
+	return n, e
+}
+
+// Compact rewrites all track files that shrink with the current encoding.
+func (d DiskDB) Compact() (n int, before, after int64, e error) {
+	return d.rewrite(func(b []byte, f File) bool {
+		var w bytes.Buffer
+		return f.Encode(&w) == nil && w.Len() < len(b)
+	})
+}
+
+// rewrite encodes all track files again for which need is true.
+// It returns the number of rewritten files and the total db size before and after.
+func (d DiskDB) rewrite(need func(b []byte, f File) bool) (n int, before, after int64, e error) {
+	for i := 0; i < d.Len(); i++ {
+		h := d.Head(i)
+		if h.Samples == 0 {
+			continue
+		}
+		name := d.idpath(h.Start)
+		b, e := os.ReadFile(name)
+		if os.IsNotExist(e) {
+			continue
+		} else if e != nil {
+			return n, before, after, e
+		}
+		before += int64(len(b))
+		f, e := Decode(b)
+		if e != nil {
+			return n, before, after, fmt.Errorf("%d: %s", h.Start, e)
+		}
+		if need(b, f) == false {
+			after += int64(len(b))
+			continue
+		}
+		var w bytes.Buffer
+		if e := f.Encode(&w); e != nil {
+			return n, before, after, fmt.Errorf("%d: %s", h.Start, e)
+		}
+		after += int64(w.Len())
+		if e := writeFile(name, func(o io.Writer) error { _, e := w.WriteTo(o); return e }); e != nil {
+			return n, before, after, fmt.Errorf("%d: %s", h.Start, e)
+		}
+		n++
+	}
+	return n, before, after, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func testFile() File {
+	f := File{Header: Header{Start: 1439649908, Type: 1, Seconds: 3, Meters: 12.5, Samples: 4, Zone: 7200}}
+	f.Time = []float32{0, 1, 2, 3}
+	f.Dist = []float32{0, 4, 8.5, 12.5}
+	f.Alt = []float32{500, 500.5, 501, 502}
+	f.Lat = []int32{703406080, 703406100, 703406120, invalidSemis}
+	f.Lon = []int32{68345260, 68345280, 68345300, invalidSemis}
+	f.Hr = []uint16{120, 121, invalidU16, 125}
+	f.Temp = []int16{20, 20, 21, invalidI16}
+	f.Laps = []Lap{{0, 2, 8.5, 0}, {2, 1, 4, 7}}
+	return f
+}
+
+func TestEncodeDecode(t *testing.T) {
+	f := testFile()
+	var b bytes.Buffer
+	if e := f.Encode(&b); e != nil {
+		t.Fatal(e)
+	}
+	if v := Version(b.Bytes()); v != formatVersion {
+		t.Fatalf("version %d", v)
+	}
+	g, e := Decode(b.Bytes())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(f, g) {
+		t.Fatalf("got %+v\nexpected %+v", g, f)
+	}
+}
+
+// encode1 writes format version 1: header without Zone and raw columns.
+func encode1(f File, ids ...uint16) []byte {
+	var data, b bytes.Buffer
+	var dir []column
+	for _, id := range ids {
+		p := f.column(id)
+		binary.Write(&data, le, p)
+		dir = append(dir, column{Id: id, Enc: encRaw, Size: uint32(binary.Size(p))})
+	}
+	binary.Write(&b, le, magic)
+	binary.Write(&b, le, uint32(1))
+	binary.Write(&b, le, header1{f.Start, f.Type, f.Seconds, f.Meters, f.Samples})
+	binary.Write(&b, le, uint32(len(dir)))
+	binary.Write(&b, le, dir)
+	data.WriteTo(&b)
+	return b.Bytes()
+}
+
+func TestDecodeV1(t *testing.T) {
+	f := testFile()
+	g, e := Decode(encode1(f, colTime, colDist, colAlt, colLat, colLon, colHr, colTemp, colLaps))
+	if e != nil {
+		t.Fatal(e)
+	}
+	f.Zone = noZone
+	if !reflect.DeepEqual(f, g) {
+		t.Fatalf("got %+v\nexpected %+v", g, f)
+	}
+	for _, ids := range [][]uint16{
+		{colTime, colAlt, colLat, colLon}, // no Dist
+		{colTime, colDist, colAlt, colLat},
+	} {
+		if _, e := Decode(encode1(f, ids...)); e == nil {
+			t.Fatalf("missing column: expected an error %v", ids)
+		}
+	}
+	b := encode1(f, colTime, colDist, colAlt, colLat, colLon)
+	if _, e := Decode(b[:len(b)-1]); e == nil {
+		t.Fatal("truncated: expected an error")
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	f := testFile()
+	var b bytes.Buffer
+	binary.Write(&b, le, header1{f.Start, f.Type, f.Seconds, f.Meters, f.Samples})
+	for _, c := range []interface{}{f.Time, f.Dist, f.Alt, f.Lat, f.Lon} {
+		binary.Write(&b, le, c)
+	}
+	if v := Version(b.Bytes()); v != 0 {
+		t.Fatalf("version %d", v)
+	}
+	g, e := Decode(b.Bytes())
+	if e != nil {
+		t.Fatal(e)
+	}
+	f.Zone, f.Hr, f.Temp, f.Laps = noZone, nil, nil, nil
+	if !reflect.DeepEqual(f, g) {
+		t.Fatalf("got %+v\nexpected %+v", g, f)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	f := testFile()
+	f.Time = []float32{0, 1.0 / 3, 2, 3}                // raw
+	f.Dist = []float32{0, 0.001, 8.5, 12.5}             // scaled 1000
+	f.Alt = []float32{500.04, 500.5, -1.26, 502}        // quantised to 0.1
+	f.Speed = []float32{2.5, float32(math.NaN()), 3, 0} // NaN survives scaling
+	var b bytes.Buffer
+	if e := f.Encode(&b); e != nil {
+		t.Fatal(e)
+	}
+	r := bytes.NewReader(b.Bytes()[8+binary.Size(Header{}):])
+	var n uint32
+	binary.Read(r, le, &n)
+	dir := make([]column, n)
+	binary.Read(r, le, dir)
+	enc := make(map[uint16]uint16)
+	for _, c := range dir {
+		enc[c.Id] = c.Enc
+	}
+	for id, x := range map[uint16]uint16{colTime: encRaw, colDist: encScaled, colAlt: encScaled, colLat: encVarint, colHr: encVarint, colTemp: encVarint, colSpeed: encScaled, colLaps: encRaw} {
+		if enc[id] != x {
+			t.Fatalf("column %d: encoding %d, expected %d", id, enc[id], x)
+		}
+	}
+	g, e := Decode(b.Bytes())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !math.IsNaN(float64(g.Speed[1])) {
+		t.Fatalf("speed: %v", g.Speed)
+	}
+	f.Alt = []float32{500, 500.5, -1.3, 502}
+	f.Speed[1], g.Speed[1] = 0, 0
+	if !reflect.DeepEqual(f, g) {
+		t.Fatalf("got %+v\nexpected %+v", g, f)
+	}
+}
+
+func TestDeltas(t *testing.T) {
+	v := []int64{0, 1, -1, 1 << 40, -(1 << 40), 5}
+	var b bytes.Buffer
+	putDeltas(&b, v)
+	r, e := getDeltas(b.Bytes(), len(v))
+	if e != nil || !reflect.DeepEqual(r, v) {
+		t.Fatalf("got %v %v", r, e)
+	}
+	if _, e := getDeltas(b.Bytes()[:b.Len()-1], len(v)); e == nil {
+		t.Fatal("truncated: expected an error")
+	}
+}
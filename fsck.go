@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Problem is an inconsistency found by Fsck.
+type Problem struct {
+	Kind  string // missing|orphan|samples|decode|duplicate|nan|race
+	Id    int64
+	Msg   string
+	Fixed bool // by repair
+}
+
+func (p Problem) String() string {
+	s := fmt.Sprintf("%-9s %d %s", p.Kind, p.Id, p.Msg)
+	if p.Fixed {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// Fsck checks the index against the track files and races.
+// With repair, the index is rewritten: missing files set Samples to 0, sample counts and NaN headers
+// are taken from the track file, duplicates are dropped and orphan files are added back.
+// Decode errors and races without an activity on that day are only reported.
+func (d *DiskDB) Fsck(repair bool) (p []Problem, e error) {
+	dir, e := ioutil.ReadDir(d.dir)
+	if e != nil {
+		return nil, e
+	}
+	files := make(map[int64]bool)
+	for _, fi := range dir {
+		if id, e := strconv.ParseInt(fi.Name(), 10, 64); e == nil && fi.Mode().IsRegular() {
+			files[id] = true
+		}
+	}
+	add := func(kind string, id int64, fixed bool, format string, a ...interface{}) {
+		p = append(p, Problem{kind, id, fmt.Sprintf(format, a...), fixed && repair})
+	}
+	bad := func(x float32) bool { return math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) }
+	var index []Header
+	days := make(map[string]bool)
+	for i, h := range d.index {
+		id := h.Start
+		if i > 0 && id == d.index[i-1].Start {
+			add("duplicate", id, true, "index line %d", 1+i)
+			continue
+		}
+		var f File
+		decoded := false
+		if files[id] {
+			b, e := ioutil.ReadFile(d.idpath(id))
+			if e == nil {
+				f, e = Decode(b)
+			}
+			if e != nil {
+				add("decode", id, false, "%s", e)
+			} else {
+				decoded = true
+			}
+		} else if h.Samples > 0 {
+			add("missing", id, true, "samples=%d but no file", h.Samples)
+			h.Samples = 0
+		}
+		if decoded && f.Samples != h.Samples {
+			add("samples", id, true, "index=%d file=%d", h.Samples, f.Samples)
+			h.Samples = f.Samples
+		}
+		if bad(h.Seconds) || bad(h.Meters) {
+			add("nan", id, true, "%s", h.Indexline())
+			h.Seconds, h.Meters = 0, 0
+			if decoded && f.Samples > 0 {
+				h.Seconds, h.Meters = f.Time[f.Samples-1], f.Dist[f.Samples-1]
+			}
+		}
+		index = append(index, h)
+		days[h.Local().Format("20060102")] = true
+	}
+	var orphans []int64
+	for id := range files {
+		if _, o := search(d, id); o == false {
+			orphans = append(orphans, id)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i] < orphans[j] })
+	for _, id := range orphans {
+		b, e := ioutil.ReadFile(d.idpath(id))
+		if e != nil {
+			return p, e
+		}
+		f, e := Decode(b)
+		if e != nil {
+			add("orphan", id, false, "not in index: %s", e)
+		} else if f.Start != id {
+			add("orphan", id, false, "not in index: file start is %d", f.Start)
+		} else {
+			add("orphan", id, true, "not in index")
+			index = append(index, f.Header)
+			days[f.Local().Format("20060102")] = true
+		}
+	}
+	for _, r := range d.races {
+		if day := unix(r.Start).Format("20060102"); days[day] == false {
+			add("race", r.Start, false, "no activity on %s: %s", day, r.String())
+		}
+	}
+	fixed := 0
+	for _, x := range p {
+		if x.Fixed {
+			fixed++
+		}
+	}
+	if fixed == 0 {
+		return p, nil
+	}
+	sort.SliceStable(index, func(i, j int) bool { return index[i].Start < index[j].Start })
+	t := d.begin()
+	if e := t.index(index); e != nil {
+		t.abort()
+		return p, e
+	}
+	if e := t.commit(); e != nil {
+		return p, e
+	}
+	d.index = index
+	d.link()
+	d.cells.m = nil // reload indexes orphans that were added back
+	return p, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Gear is a shoe or bike, one line in db/gear.txt:
+//
+//	name sport limit(km) span..
+//	trail R 800 2023.01.01-2023.06.30 2024
+//
+// It is the default gear for activities of the sport that start within a span.
+// An activity can name its gear explicitly with -set gear=name (meta.txt).
+type Gear struct {
+	Name  string
+	Type  uint32   // sport
+	Limit float64  // retirement distance (km), 0: none
+	Spans []string // ParseSpan format
+}
+type GearTotal struct {
+	Gear
+	N     int
+	Hours float64
+	Km    float64
+}
+
+func (d DiskDB) gearpath() string { return filepath.Join(d.dir, "gear.txt") }
+
+func ParseGear(s string) (g Gear, e error) {
+	v := strings.Fields(s)
+	if len(v) < 3 {
+		return g, fmt.Errorf("gear: %s: expected name sport limit span..", s)
+	}
+	g.Name = v[0]
+	if g.Type, e = parseSport(v[1]); e != nil {
+		return g, fmt.Errorf("gear: %s: %s", s, e)
+	}
+	if g.Limit, e = strconv.ParseFloat(v[2], 64); e != nil || g.Limit < 0 {
+		return g, fmt.Errorf("gear: %s: parse limit", s)
+	}
+	for _, x := range v[3:] {
+		if _, _, e := ParseSpan(x); e != nil {
+			return g, fmt.Errorf("gear: %s: %s", s, e)
+		}
+	}
+	g.Spans = v[3:]
+	return g, nil
+}
+func (g Gear) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %c %v %s", g.Name, sport(g.Type), g.Limit, strings.Join(g.Spans, " ")))
+}
+func (g Gear) covers(h Header) bool {
+	if h.Type != g.Type && h.Type&0xff != g.Type { // sport gear covers all sub-sports
+		return false
+	}
+	for _, s := range g.Spans {
+		if start, end, e := ParseSpan(s); e == nil && h.wall() >= start && h.wall() < end {
+			return true
+		}
+	}
+	return false
+}
+func ReadGear(r io.Reader) (g []Gear, e error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if t := strings.TrimSpace(s.Text()); t != "" {
+			x, e := ParseGear(t)
+			if e != nil {
+				return nil, e
+			}
+			g = append(g, x)
+		}
+	}
+	return g, s.Err()
+}
+func openGear(name string) ([]Gear, error) {
+	fp, e := os.Open(name)
+	if os.IsNotExist(e) {
+		return nil, nil
+	} else if e != nil {
+		return nil, e
+	}
+	defer fp.Close()
+	g, e := ReadGear(fp)
+	if e != nil {
+		return nil, fmt.Errorf("%s: %s", name, e)
+	}
+	return g, nil
+}
+
+// GearOf returns the gear that was used for h: the explicit one from meta.txt
+// or the last matching default in gear.txt.
+func GearOf(db DB, h Header) string {
+	d := disk(db)
+	if d == nil {
+		return ""
+	}
+	if g := d.meta[h.Start].Gear; g != "" {
+		return g
+	}
+	r := ""
+	for _, g := range d.gear {
+		if g.covers(h) {
+			r = g.Name
+		}
+	}
+	return r
+}
+
+// GearTotals sums the activities for each gear.
+func GearTotals(db DB) (r []GearTotal) {
+	d := disk(db)
+	if d == nil {
+		return nil
+	}
+	for _, g := range d.gear {
+		name := g.Name
+		n, t, km, _ := Totals(FilterH(db, func(h Header) bool { return GearOf(db, h) == name }))
+		r = append(r, GearTotal{Gear: g, N: n, Hours: t.Hours(), Km: km})
+	}
+	return r
+}
+func (g GearTotal) String() string {
+	s := fmt.Sprintf("%s %c #%d %.0fh %.0fkm", g.Name, sport(g.Type), g.N, g.Hours, g.Km)
+	if g.Limit > 0 {
+		s += fmt.Sprintf("/%.0fkm", g.Limit)
+	}
+	return s
+}
+func (g GearTotal) Worn() bool { return g.Limit > 0 && g.Km > g.Limit }
+
+// GearWarnings prints a warning for each gear that passed its distance limit.
+func GearWarnings(w io.Writer, db DB) {
+	for _, g := range GearTotals(db) {
+		if g.Worn() {
+			fmt.Fprintf(w, "warning: gear %s: %.0fkm passed limit %.0fkm\n", g.Name, g.Km, g.Limit)
+		}
+	}
+}
+
+func (d DiskDB) findGear(name string) int {
+	for i, g := range d.gear {
+		if g.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddGear adds or replaces (by name) a gear in gear.txt.
+func (d *DiskDB) AddGear(g Gear) error {
+	gear := append([]Gear{}, d.gear...)
+	if i := d.findGear(g.Name); i >= 0 {
+		gear[i] = g
+	} else {
+		gear = append(gear, g)
+	}
+	return d.setGear(gear)
+}
+func (d *DiskDB) RemoveGear(name string) error {
+	i := d.findGear(name)
+	if i < 0 {
+		return fmt.Errorf("gear not found: %s", name)
+	}
+	return d.setGear(append(append([]Gear{}, d.gear[:i]...), d.gear[i+1:]...))
+}
+func (d *DiskDB) setGear(gear []Gear) error {
+	t := d.begin()
+	if e := t.gear(gear); e != nil {
+		t.abort()
+		return e
+	}
+	if e := t.commit(); e != nil {
+		return e
+	}
+	d.gear = gear
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type gpx struct {
+	Trk []struct {
+		Type string `xml:"type"`
+		Seg  []struct {
+			Pt []struct {
+				Lat  float64  `xml:"lat,attr"`
+				Lon  float64  `xml:"lon,attr"`
+				Ele  *float64 `xml:"ele"`
+				Time string   `xml:"time"`
+				Hr   uint16   `xml:"extensions>TrackPointExtension>hr"`
+				Cad  uint16   `xml:"extensions>TrackPointExtension>cad"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// gpxStrava maps the numeric <type> of strava (and older) gpx files to sport aliases.
+var gpxStrava = map[int]string{
+	1: "ride", 2: "alpineski", 3: "backcountryski", 4: "hike", 5: "iceskate", 6: "inlineskate", 7: "nordicski",
+	8: "rollerski", 9: "run", 10: "walk", 11: "workout", 12: "snowboard", 13: "snowshoe", 14: "kitesurf",
+	15: "windsurf", 16: "swim", 17: "virtualride", 18: "ebikeride", 19: "velomobile", 21: "canoe", 22: "kayaking",
+	23: "rowing", 24: "standuppaddling", 25: "surfing", 26: "crossfit", 27: "elliptical", 28: "rockclimb",
+	29: "stairstepper", 30: "weighttraining", 31: "yoga", 51: "handcycle", 53: "virtualrun",
+}
+
+// gpxType returns the sport of a <type> name or strava number, "" for unknown numbers.
+func gpxType(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if n, e := strconv.Atoi(s); e == nil {
+		return gpxStrava[n]
+	}
+	return s
+}
+
+// ReadGpx reads all track points of a gpx file. The distance is accumulated from the coordinates.
+// The sport is taken from <type>, unless sport is given (letter, name or number, see parseSport).
+func ReadGpx(file, sport string) (File, error) {
+	fp, e := os.Open(file)
+	if e != nil {
+		return File{}, e
+	}
+	defer fp.Close()
+	return readGpx(fp, file, sport)
+}
+func readGpx(r io.Reader, file, sport string) (f File, e error) {
+	var g gpx
+	if e := xml.NewDecoder(r).Decode(&g); e != nil {
+		return f, fmt.Errorf("%s: %s", file, e)
+	}
+	var start time.Time
+	lat0, lon0, dist := math.NaN(), math.NaN(), 0.0
+	for _, t := range g.Trk {
+		if sport == "" {
+			sport = gpxType(t.Type)
+		}
+		for _, s := range t.Seg {
+			for _, p := range s.Pt {
+				tm, e := time.Parse(time.RFC3339, strings.TrimSpace(p.Time))
+				if e != nil {
+					return f, fmt.Errorf("%s: trkpt time: %s", file, e)
+				}
+				if start.IsZero() {
+					start = tm
+				}
+				lat, lon := rad(p.Lat), rad(p.Lon)
+				if !math.IsNaN(lat0) {
+					dist += Vincenty(lat0, lon0, lat, lon)
+				}
+				lat0, lon0 = lat, lon
+				alt := float32(math.NaN())
+				if p.Ele != nil {
+					alt = float32(*p.Ele)
+				}
+				hr, cad := invalidU16, invalidU16
+				if p.Hr != 0 {
+					hr = p.Hr
+				}
+				if p.Cad != 0 {
+					cad = p.Cad
+				}
+				f.Time = append(f.Time, float32(tm.Sub(start).Seconds()))
+				f.Dist = append(f.Dist, float32(dist))
+				f.Alt = append(f.Alt, alt)
+				f.Lat = append(f.Lat, Semis(p.Lat))
+				f.Lon = append(f.Lon, Semis(p.Lon))
+				f.Hr = append(f.Hr, hr)
+				f.Cad = append(f.Cad, cad)
+			}
+		}
+	}
+	n := len(f.Time)
+	if n == 0 {
+		return f, fmt.Errorf("%s: no track points", file)
+	}
+	if sport == "" {
+		return f, fmt.Errorf("%s: no sport type (use -type)", file)
+	}
+	typ, e := parseSport(sport)
+	if e != nil {
+		return f, fmt.Errorf("%s: %s", file, e)
+	}
+	f.Header = Header{
+		Start:   start.Unix(),
+		Type:    typ,
+		Seconds: f.Time[n-1],
+		Meters:  f.Dist[n-1],
+		Samples: uint64(n),
+		Zone:    noZone,
+	}
+	f.dropInvalid()
+	return f, nil
+}
@@ -17,9 +17,10 @@ func Here(db DB, s string) SubDB {
 	if len(v) != 2 {
 		fatal(fmt.Errorf("here: expect lat,lon (got %s)", s))
 	}
-	la, lo := rad(parseFloat(v[0])), rad(parseFloat(v[1]))
+	lat, lon := parseFloat(v[0]), parseFloat(v[1])
+	la, lo := rad(lat), rad(lon)
 
-	r := Filter(db, func(f File) bool {
+	r := Filter(NearPoint(db, lat, lon, near), func(f File) bool {
 		for i := uint64(0); i < f.Samples; i++ {
 			lat, lon := rad(Deg(f.Lat[i])), rad(Deg(f.Lon[i]))
 			if !math.IsNaN(lat) && !math.IsNaN(lon) {
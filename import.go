@@ -8,44 +8,53 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func importHeader(s string) (File, error) {
+// importHeader parses a manual entry: sport time km duration [+climb] [title [| note]]
+func importHeader(s string) (File, Meta, error) {
 	var f File
-	use := fmt.Errorf("import header: R 20230607T080000 10.2 39m2s")
+	var m Meta
+	use := fmt.Errorf(`manual entry: sport time km duration [+climb] [title [| note]], e.g. "R 20230607T080000 10.2 39m2s +120 hills | felt good"`)
 	v := strings.Fields(s)
-	if len(s) < len("R 20230102T123344 1 1m2s") || len(v) != 4 {
-		return f, use
+	if len(v) < 4 {
+		return f, m, use
 	}
-	isrun := false
-	if v[0] == "R" {
-		isrun = true
-	} else if v[0] != "B" {
-		return f, use
+	typ, e := parseSport(v[0])
+	if e != nil {
+		return f, m, e
 	}
-	t, e := time.Parse("20060102T150405", v[1])
+	t, dateonly, e := parseWhen(v[1])
 	if e != nil {
-		return f, use
+		return f, m, use
+	} else if dateonly {
+		t = t.Add(12 * time.Hour)
 	}
-	km, e := strconv.ParseFloat(v[2], 64)
+	km, e := parseKm(v[2])
 	if e != nil {
-		return f, use
+		return f, m, use
 	}
-	dur, e := time.ParseDuration(v[3])
+	dur, e := parseDur(v[3])
 	if e != nil {
-		return f, use
+		return f, m, use
+	}
+	v = v[4:]
+	if len(v) > 0 && strings.HasPrefix(v[0], "+") {
+		if e := m.set("climb", v[0][1:]); e != nil {
+			return f, m, e
+		}
+		v = v[1:]
 	}
-	f.Header.Start = t.Unix()
-	f.Header.Type = 1
-	if isrun == false {
-		f.Header.Type = 2
+	text := strings.SplitN(strings.Join(v, " "), "|", 2)
+	m.set("title", text[0])
+	if len(text) == 2 {
+		m.set("note", text[1])
 	}
-	f.Header.Seconds = float32(dur.Seconds())
-	f.Header.Meters = float32(1000.0 * km)
-	return f, nil
+	f.Header = Header{Start: t.Unix(), Type: typ, Seconds: float32(dur.Seconds()), Meters: float32(1000 * km), Zone: noZone}
+	return f, m, nil
 }
 func importDB(src, dst string) {
 	if d, e := ioutil.ReadDir(dst); e != nil {
@@ -56,14 +65,23 @@ func importDB(src, dst string) {
 	fatal(ioutil.WriteFile(filepath.Join(dst, "index.txt"), nil, 0644))
 	fatal(ioutil.WriteFile(filepath.Join(dst, "race.txt"), nil, 0644))
 
-	heads, race := importIndex(src)
+	heads, race, meta := importIndex(src)
 	fmt.Println(len(heads), len(race))
+	sort.Slice(heads, func(i, j int) bool { return heads[i].Start < heads[j].Start }) // append only
 
 	db, e := OpenDB(dst)
 	fatal(e)
 	notrack := 0
 	for _, h := range heads {
-		if f, e := importJson(src, h); e == nil || os.IsNotExist(e) {
+		if f, m, e := importJson(src, h); e == nil || os.IsNotExist(e) {
+			x := meta[h.Start]
+			if x.Title == "" {
+				x.Title = m.Title
+			}
+			x.Note = m.Note
+			if x.Empty() == false {
+				meta[h.Start] = x
+			}
 			if f.Samples > 0 {
 				fmt.Println(f.Start, f.Samples)
 				if len(f.Dist) > 0 && len(f.Alt) == 0 && len(f.Lat) == 0 && len(f.Lon) == 0 {
@@ -82,6 +100,8 @@ func importDB(src, dst string) {
 		}
 	}
 
+	fatal(db.PutMeta(meta))
+
 	f, e := os.Create(db.racepath())
 	fatal(e)
 	defer f.Close()
@@ -90,12 +110,12 @@ func importDB(src, dst string) {
 	}
 	fmt.Println("no track", notrack)
 }
-func importJson(dir string, h Header) (f File, err error) {
+func importJson(dir string, h Header) (f File, m Meta, err error) {
 	f.Header = h
 	name := filepath.Join(dir, unix(h.Start).Format("2006/20060102T150405.json"))
 	b, e := ioutil.ReadFile(name)
 	if e != nil {
-		return f, e
+		return f, m, e
 	}
 	keys := []string{"start", "type", "title", "time", "dist", "desc", "lap", "track", "points", "lat", "lon", "elev"}
 	for _, k := range keys {
@@ -133,6 +153,8 @@ func importJson(dir string, h Header) (f File, err error) {
 		Time  string `json:time`
 		Dist  jfloat `json:dist`
 		Lap   []l    `json:lap`
+		Title string
+		Desc  string
 	}
 	var d t
 	if e := json.Unmarshal(b, &d); e != nil {
@@ -140,6 +162,7 @@ func importJson(dir string, h Header) (f File, err error) {
 		fatal(e)
 	}
 
+	m = Meta{Title: d.Title, Note: strings.Join(strings.Fields(d.Desc), " ")}
 	for _, l := range d.Lap {
 		tk := l.Track
 		f.Time = append(f.Time, jfloats32(tk.Time)...)
@@ -151,20 +174,20 @@ func importJson(dir string, h Header) (f File, err error) {
 
 	samples := len(f.Time)
 	if len(f.Dist) != samples {
-		return f, fmt.Errorf("%s: uniform/dist", name)
+		return f, m, fmt.Errorf("%s: uniform/dist", name)
 	}
 	n := len(f.Alt)
 	if len(f.Lat) != n || len(f.Lon) != n {
-		return f, fmt.Errorf("%s: uniform/alt/lat/lon", name)
+		return f, m, fmt.Errorf("%s: uniform/alt/lat/lon", name)
 	}
 	if n > 0 && n != samples {
-		return f, fmt.Errorf("%s: uniform/samples %d/%d", name, n, samples)
+		return f, m, fmt.Errorf("%s: uniform/samples %d/%d", name, n, samples)
 	}
 
 	f.Samples = uint64(samples)
-	return f, nil
+	return f, m, nil
 }
-func importIndex(dir string) (h []Header, r []Race) {
+func importIndex(dir string) (h []Header, r []Race, m map[int64]Meta) {
 	index, e := ioutil.ReadFile(filepath.Join(dir, "index.json"))
 	fatal(e)
 	keys := []string{"type", "time", "dist", "climb", "laps", "agresult", "result", "racetime", "racetype", "title", "list", "links"}
@@ -246,11 +269,13 @@ func importIndex(dir string) (h []Header, r []Race) {
 			Type:    parseType(x.Type),
 			Seconds: float32(racetime(x.Time).Seconds()),
 			Meters:  parseFloat32(x.Dist),
+			Zone:    noZone,
 		}
 	}
 	var d []hdr
 	fatal(json.Unmarshal(index, &d))
 
+	m = make(map[int64]Meta)
 	for _, x := range d {
 		if x.Type == "C" {
 			r = append(r, race(x))
@@ -259,9 +284,12 @@ func importIndex(dir string) (h []Header, r []Race) {
 				continue
 			}
 			h = append(h, head(x))
+			if x.Title != "" {
+				m[fileTime(x.File)] = Meta{Title: x.Title}
+			}
 		}
 	}
-	return h, r
+	return h, r, m
 }
 
 type jfloat float64
@@ -287,12 +315,7 @@ func jsemis(j []jfloat) []int32 {
 	}
 	return r
 }
-func (j jfloat) semi() int32 {
-	if math.IsNaN(float64(j)) {
-		return 0x7FFFFFFF
-	}
-	return int32(math.Pow(2, 31) * float64(j) / 180.0)
-}
+func (j jfloat) semi() int32 { return Semis(float64(j)) }
 func invalids(n int) []int32 {
 	r := make([]int32, n)
 	for i := range r {
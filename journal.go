@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Operations that change several files in the db directory go through a journal.
+// Track files and a new index are first written to temporary files (*.<pid>.tmp) and synced.
+// Commit writes db/journal.<pid>.txt, which lists the steps and ends with "commit".
+// The process id keeps the files of the server and a command line run apart.
+// After that the steps are applied and the journal is removed.
+//
+// If applying fails, the steps that were done are undone and the db is left as it was.
+// If the program dies after the commit, OpenDB completes the journal (see recover).
+// Temporary files of a journal without the commit line are removed, others are left alone.
+//
+// journal lines:
+//
+//	put <id>          rename <id>.<pid>.tmp to <id>
+//	index             rename index.txt.<pid>.tmp to index.txt
+//	races             rename race.txt.<pid>.tmp to race.txt
+//	meta              rename meta.txt.<pid>.tmp to meta.txt
+//	gear              rename gear.txt.<pid>.tmp to gear.txt
+//	cells             rename cells.txt.<pid>.tmp to cells.txt
+//	append <header>   append the index line (unless the id is present)
+//	rm <id>           remove <id>
+//	commit
+type tx struct {
+	d   *DiskDB
+	ops []string
+}
+
+var pid = strconv.Itoa(os.Getpid())
+
+func (d *DiskDB) begin() *tx                   { return &tx{d: d} }
+func (d DiskDB) journalpath(pid string) string { return filepath.Join(d.dir, "journal."+pid+".txt") }
+func tmppath(name, pid string) string          { return name + "." + pid + ".tmp" }
+func (t *tx) put(f File) error {
+	t.ops = append(t.ops, "put "+strconv.FormatInt(f.Start, 10))
+	return writeTemp(t.d.filepath(f), f.Encode)
+}
+func (t *tx) index(h []Header) error {
+	t.ops = append(t.ops, "index")
+	return writeTemp(t.d.indexpath(), func(w io.Writer) (e error) {
+		for _, h := range h {
+			_, err := fmt.Fprintln(w, h.Indexline())
+			e = do(e, err)
+		}
+		return e
+	})
+}
+func (t *tx) races(r []Race) error {
+	t.ops = append(t.ops, "races")
+	return writeTemp(t.d.racepath(), func(w io.Writer) (e error) {
+		for _, r := range r {
+			_, err := fmt.Fprintln(w, r.String())
+			e = do(e, err)
+		}
+		return e
+	})
+}
+func (t *tx) metadata(m map[int64]Meta) error {
+	t.ops = append(t.ops, "meta")
+	return writeTemp(t.d.metapath(), func(w io.Writer) error { return WriteMeta(w, m) })
+}
+func (t *tx) gear(g []Gear) error {
+	t.ops = append(t.ops, "gear")
+	return writeTemp(t.d.gearpath(), func(w io.Writer) (e error) {
+		for _, g := range g {
+			_, err := fmt.Fprintln(w, g.String())
+			e = do(e, err)
+		}
+		return e
+	})
+}
+func (t *tx) cells(drop ...int64) error { // the loader indexes dropped tracks again
+	t.ops = append(t.ops, "cells")
+	b, e := ioutil.ReadFile(t.d.cellpath())
+	if os.IsNotExist(e) {
+		return nil
+	} else if e != nil {
+		return e
+	}
+	m := make(map[int64]bool)
+	for _, id := range drop {
+		m[id] = true
+	}
+	return writeTemp(t.d.cellpath(), func(w io.Writer) (e error) {
+		for _, s := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			id, _ := strconv.ParseInt(strings.SplitN(s, " ", 2)[0], 10, 64)
+			if s != "" && m[id] == false {
+				_, err := fmt.Fprintln(w, s)
+				e = do(e, err)
+			}
+		}
+		return e
+	})
+}
+func (t *tx) append(h Header) { t.ops = append(t.ops, "append "+h.Indexline()) }
+func (t *tx) rm(id int64)     { t.ops = append(t.ops, "rm "+strconv.FormatInt(id, 10)) }
+
+// abort removes the temporary files of an uncommitted transaction.
+func (t *tx) abort() {
+	for _, op := range t.ops {
+		if name := t.d.tmpname(op, pid); name != "" {
+			os.Remove(name)
+		}
+	}
+}
+func (t *tx) commit() error {
+	e := writeFile(t.d.journalpath(pid), func(w io.Writer) error {
+		_, e := io.WriteString(w, strings.Join(t.ops, "\n")+"\ncommit\n")
+		return e
+	})
+	if e != nil {
+		t.abort()
+		return e
+	}
+	syncDir(t.d.dir)
+	var undo []func() error
+	for _, op := range t.ops {
+		if e = t.d.apply(op, pid, &undo); e != nil {
+			break
+		}
+	}
+	if e != nil {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](); err != nil { // keep the journal: recover completes the change
+				return fmt.Errorf("%s (undo: %s, the change is completed on the next start)", e, err)
+			}
+		}
+		t.abort()
+	}
+	os.Remove(t.d.journalpath(pid))
+	syncDir(t.d.dir)
+	return e
+}
+
+// tmpname returns the temporary file written for a journal step by process pid.
+func (d DiskDB) tmpname(op, pid string) string {
+	if strings.HasPrefix(op, "put ") {
+		return tmppath(filepath.Join(d.dir, op[4:]), pid)
+	} else if name := d.textfile(op); name != "" {
+		return tmppath(name, pid)
+	}
+	return ""
+}
+
+// textfile returns the file that a journal step replaces as a whole.
+func (d DiskDB) textfile(op string) string {
+	switch op {
+	case "index":
+		return d.indexpath()
+	case "races":
+		return d.racepath()
+	case "meta":
+		return d.metapath()
+	case "gear":
+		return d.gearpath()
+	case "cells":
+		return d.cellpath()
+	}
+	return ""
+}
+
+// apply does one journal step. It is idempotent, such that recover can repeat it.
+// With undo != nil, it records how to revert it. Undo also restores the temporary file,
+// such that a journal whose undo failed half way can still be completed.
+func (d DiskDB) apply(op, pid string, undo *[]func() error) error {
+	switch {
+	case strings.HasPrefix(op, "put "), d.textfile(op) != "":
+		tmp := d.tmpname(op, pid)
+		name := strings.TrimSuffix(tmp, "."+pid+".tmp")
+		if _, e := os.Stat(tmp); os.IsNotExist(e) {
+			return nil // done
+		}
+		if undo != nil {
+			old, e := ioutil.ReadFile(name)
+			if e != nil && os.IsNotExist(e) == false {
+				return e
+			}
+			*undo = append(*undo, func() error {
+				if e := os.Rename(name, tmp); e != nil || old == nil {
+					return e
+				}
+				return ioutil.WriteFile(name, old, 0644) // a partial write is replaced by recover
+			})
+		}
+		return os.Rename(tmp, name)
+	case strings.HasPrefix(op, "append "):
+		h, e := ParseHeader(op[7:])
+		if e != nil {
+			return e
+		}
+		b, e := ioutil.ReadFile(d.indexpath())
+		if e != nil && os.IsNotExist(e) == false {
+			return e
+		}
+		n := len(b)
+		if i := bytes.LastIndexByte(b, '\n'); i+1 < n {
+			if _, e := ParseHeader(string(b[i+1:])); e != nil {
+				n = i + 1 // drop a partially written line
+			}
+		}
+		s := bufio.NewScanner(bytes.NewReader(b[:n]))
+		for s.Scan() {
+			if g, e := ParseHeader(s.Text()); e == nil && g.Start == h.Start {
+				return nil // done
+			}
+		}
+		if undo != nil {
+			*undo = append(*undo, func() error { return os.Truncate(d.indexpath(), int64(len(b))) })
+		}
+		w, e := os.OpenFile(d.indexpath(), os.O_CREATE|os.O_WRONLY, 0644)
+		if e != nil {
+			return e
+		}
+		line := h.Indexline() + "\n"
+		if n > 0 && b[n-1] != '\n' {
+			line = "\n" + line
+		}
+		_, e = w.WriteAt([]byte(line), int64(n))
+		e = do(e, w.Truncate(int64(n+len(line))))
+		e = do(e, w.Sync())
+		return do(e, w.Close())
+	case strings.HasPrefix(op, "rm "):
+		if e := os.Remove(filepath.Join(d.dir, op[3:])); e != nil && os.IsNotExist(e) == false {
+			return e
+		}
+		return nil
+	}
+	return fmt.Errorf("journal: unknown step: %s", op)
+}
+
+// recover completes committed journals and removes the temporary files they name.
+// Other temporary files are left alone: they may belong to a transaction
+// of another process (e.g. the server) that is not yet committed.
+func (d DiskDB) recover() error {
+	journals, _ := filepath.Glob(d.journalpath("*"))
+	for _, name := range journals {
+		pid := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), "journal."), ".txt")
+		b, e := ioutil.ReadFile(name)
+		if os.IsNotExist(e) {
+			continue // completed by its process
+		} else if e != nil {
+			return e
+		}
+		ops := strings.Split(strings.TrimSpace(string(b)), "\n")
+		if n := len(ops) - 1; ops[n] == "commit" {
+			for _, op := range ops[:n] {
+				if e := d.apply(op, pid, nil); e != nil {
+					return fmt.Errorf("%s: %s", name, e)
+				}
+			}
+		}
+		for _, op := range ops {
+			if tmp := d.tmpname(op, pid); tmp != "" {
+				os.Remove(tmp)
+			}
+		}
+		os.Remove(name)
+	}
+	syncDir(d.dir)
+	return nil
+}
+
+// writeTemp writes and syncs the temporary file of name.
+func writeTemp(name string, write func(w io.Writer) error) error {
+	tmp := tmppath(name, pid)
+	w, e := os.Create(tmp)
+	if e != nil {
+		return e
+	}
+	e = write(w)
+	e = do(e, w.Sync())
+	e = do(e, w.Close())
+	if e != nil {
+		os.Remove(tmp)
+	}
+	return e
+}
+
+// writeFile writes to a temporary file next to name, syncs it and renames it into place.
+func writeFile(name string, write func(w io.Writer) error) error {
+	if e := writeTemp(name, write); e != nil {
+		return e
+	}
+	e := os.Rename(tmppath(name, pid), name)
+	if e != nil {
+		os.Remove(tmppath(name, pid))
+	}
+	return e
+}
+func syncDir(dir string) { // make renames durable (not supported on all systems)
+	if d, e := os.Open(dir); e == nil {
+		d.Sync()
+		d.Close()
+	}
+}
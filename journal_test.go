@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testDB is a db with one entry, and a second one (not added).
+func testDB(t *testing.T) (*DiskDB, File) {
+	dir := t.TempDir()
+	if e := ioutil.WriteFile(filepath.Join(dir, "index.txt"), nil, 0644); e != nil {
+		t.Fatal(e)
+	}
+	d, e := OpenDB(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+	a, b := testFile(), testFile()
+	b.Start += 7200
+	if e := d.Add(a); e != nil {
+		t.Fatal(e)
+	}
+	return d, b
+}
+func ls(t *testing.T, dir string) (r []string) {
+	fs, e := os.ReadDir(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for _, f := range fs {
+		r = append(r, f.Name())
+	}
+	sort.Strings(r)
+	return r
+}
+
+// journal writes the temporary files of adding b and a journal as process 77 would.
+func journal(t *testing.T, d *DiskDB, b File, ops string) {
+	x := &tx{d: d}
+	e := do(x.put(b), x.index(append(append([]Header{}, d.index...), b.Header)))
+	for _, op := range x.ops {
+		e = do(e, os.Rename(d.tmpname(op, pid), d.tmpname(op, "77")))
+	}
+	e = do(e, ioutil.WriteFile(d.journalpath("77"), []byte(ops), 0644))
+	e = do(e, ioutil.WriteFile(tmppath(d.indexpath(), "78"), nil, 0644)) // another process
+	if e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	for _, committed := range []bool{true, false} {
+		d, b := testDB(t)
+		ops := "put 1439657108\nindex\n"
+		if committed {
+			ops += "commit\n"
+		}
+		journal(t, d, b, ops)
+		d, e := OpenDB(d.dir)
+		if e != nil {
+			t.Fatal(e)
+		}
+		files := []string{"1439649908", "index.txt", "index.txt.78.tmp"}
+		if committed {
+			files = []string{"1439649908", "1439657108", "index.txt", "index.txt.78.tmp"}
+		}
+		if r := ls(t, d.dir); !reflect.DeepEqual(r, files) {
+			t.Fatalf("committed=%v: files %v, expected %v", committed, r, files)
+		}
+		if d.Len() != len(files)-2 {
+			t.Fatalf("committed=%v: %d entries", committed, d.Len())
+		}
+	}
+}
+
+func TestCommitUndo(t *testing.T) {
+	d, b := testDB(t)
+	index, err := ioutil.ReadFile(d.indexpath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := d.begin()
+	e := do(x.put(b), x.index(append(append([]Header{}, d.index...), b.Header)))
+	x.append(b.Header)
+	x.ops = append(x.ops, "fail")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := x.commit(); e == nil {
+		t.Fatal("expected an error")
+	}
+	if r := ls(t, d.dir); !reflect.DeepEqual(r, []string{"1439649908", "index.txt"}) {
+		t.Fatalf("files %v", r)
+	}
+	if r, _ := ioutil.ReadFile(d.indexpath()); string(r) != string(index) {
+		t.Fatalf("index: %q, expected %q", r, index)
+	}
+}
@@ -13,10 +13,21 @@ func K(db DB) {
 	for i := 0; i < n; i++ {
 		h := db.Head(i)
 		id := h.Start // int32 til 2038
-		typ := sport(h.Type) + 32
+		typ := kletter(sport(h.Type))
 		time := h.Seconds / 3600.0
 		dist := h.Meters / 1000.0
 		coords := h.Samples
 		fmt.Printf("%d,%c,%.3f,%.4f,%d\n", id, typ, time, dist, coords)
 	}
 }
+
+// kletter swaps the case of the sport letter: r b s as before and distinct for all sports.
+func kletter(c byte) byte {
+	switch {
+	case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		return c ^ 0x20
+	case c == '?':
+		return '_'
+	}
+	return c
+}
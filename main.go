@@ -5,17 +5,19 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
 func main() {
-	var add, list, news, race, cal, bitmap, k, table, totals, serve, unics, years, tour bool
+	var add, list, news, race, cal, bitmap, k, table, totals, serve, unics, years, tour, migrate, compact, fsck, repair, rm, rmrace, gear, sportlist bool
 	var id int64
 	var shorts int
-	var hdr, date, dir, here, addr, fit, imprt, diff string
+	var hdr, date, dir, here, addr, fit, gpx, tcx, typ, batch, watch, imprt, diff, set, addrace, tag, addgear, rmgear, backup, restore, merge, split, diary, strava string
 	flag.BoolVar(&add, "add", false, "add/import")
-	flag.StringVar(&hdr, "hdr", "", `-add -head="R 20230607T080000 10.0 39m2s"`)
+	flag.StringVar(&hdr, "hdr", "", `-add -hdr="hiking 20230607T080000 10.0 3h2m +850 title | note" (climb, title and note are optional)`)
 	flag.BoolVar(&list, "list", false, "print header")
 	flag.BoolVar(&news, "news", false, "print list with new km")
 	flag.BoolVar(&race, "race", false, "print races")
@@ -28,40 +30,258 @@ func main() {
 	flag.BoolVar(&serve, "serve", false, "run as http server")
 	flag.BoolVar(&unics, "unix", false, "print id as date")
 	flag.Int64Var(&id, "id", 0, "use single file id")
+	flag.StringVar(&tag, "tag", "", "select activities with tag")
 	flag.StringVar(&date, "date", "", "time span 2020.09.12-2020.08.17 or year or year.month")
 	flag.StringVar(&dir, "dir", "./db/", "db directory")
 	flag.StringVar(&addr, "http", "127.0.0.1:2021", "serve on this address")
+	flag.StringVar(&watch, "watch", "", "-serve -watch dir: add new fit, gpx and tcx files (athletes: dir/name/)")
 	flag.StringVar(&fit, "fit", "", "fit file")
+	flag.StringVar(&gpx, "gpx", "", "gpx file")
+	flag.StringVar(&tcx, "tcx", "", "tcx file")
+	flag.StringVar(&typ, "type", "", "sport for -gpx, -tcx or -batch (letter, name or number), default: from the file")
+	flag.StringVar(&diary, "csv", "", "-add -csv diary.csv: add a training log (date,sport,distance,time[,climb,title,notes])")
+	flag.StringVar(&batch, "batch", "", "-add -batch dir: add all fit, gpx and tcx files in dir")
 	flag.StringVar(&imprt, "import", "", "import old db")
+	flag.StringVar(&strava, "import-strava", "", "add the activities of a strava export (zip)")
+	flag.StringVar(&backup, "backup", "", "write db to archive (tar.gz)")
+	flag.StringVar(&restore, "restore", "", "restore archive (tar.gz) to the empty db dir")
 	flag.StringVar(&diff, "diff", "", "compare fit dir against the db")
 	flag.BoolVar(&years, "years", false, "year totals")
 	flag.IntVar(&shorts, "shorts", 0, "write shorts db for year(arg) to year.shorts")
 	flag.BoolVar(&tour, "tour", false, "write tour file for span(date)")
+	flag.BoolVar(&migrate, "migrate", false, "rewrite db files in the current format")
+	flag.BoolVar(&compact, "compact", false, "rewrite db files with compact encoding")
+	flag.BoolVar(&fsck, "fsck", false, "check db consistency")
+	flag.BoolVar(&repair, "repair", false, "fsck: repair the index")
+	flag.BoolVar(&rm, "rm", false, "remove -id from the db")
+	flag.StringVar(&set, "set", "", "-id .. -set type=B,meters=10000,seconds=39m2s or title=..|note=..|tags=a,b")
+	flag.StringVar(&merge, "merge", "", "-merge id1,id2: join two entries into the first")
+	flag.StringVar(&split, "split", "", "-split id@seconds: cut an entry into two (seconds or duration, e.g. 1h2m)")
+	flag.StringVar(&addrace, "addrace", "", `-addrace="20230607T080000 10k 39m2s 101/2048 name"`)
+	flag.BoolVar(&rmrace, "rmrace", false, "-id .. remove race (id is the race start)")
+	flag.BoolVar(&gear, "gear", false, "print gear totals")
+	flag.BoolVar(&sportlist, "sports", false, "print sport letters and colours (db/sport.txt)")
+	flag.StringVar(&addgear, "addgear", "", `-addgear="trail R 800 2023.01.01-2023.06.30" (name sport limit/km default-spans..)`)
+	flag.StringVar(&rmgear, "rmgear", "", "remove gear by name")
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "github.com/ktye/kyd")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
+	fatal(readSports(filepath.Join(dir, "sport.txt")))
+	fatal(readZone(filepath.Join(dir, "zone.txt")))
+	if sportlist {
+		for _, s := range sports {
+			fmt.Println(s.String())
+		}
+		return
+	}
 
 	if imprt != "" {
 		importDB(imprt, dir)
 		return
 	}
+	if backup != "" {
+		d, e := OpenDB(dir)
+		fatal(e)
+		w, e := os.Create(backup)
+		fatal(e)
+		n, e := d.Backup(w)
+		fatal(do(e, w.Close()))
+		fmt.Println("backup", n, "files to", backup)
+		return
+	}
+	if restore != "" {
+		r, e := os.Open(restore)
+		fatal(e)
+		defer r.Close()
+		n, e := Restore(r, dir)
+		fatal(e)
+		fmt.Println("restored", n, "files to", dir)
+		return
+	}
+	if migrate {
+		d, e := OpenDB(dir)
+		fatal(e)
+		n, e := d.Migrate()
+		fmt.Println("migrated", n)
+		fatal(e)
+		return
+	}
+	if fsck {
+		d, e := OpenDB(dir)
+		fatal(e)
+		p, e := d.Fsck(repair)
+		left := 0
+		for _, x := range p {
+			fmt.Println(x)
+			if x.Fixed == false {
+				left++
+			}
+		}
+		fmt.Printf("%d entries, %d races: %d problems, %d repaired\n", d.Len(), len(d.races), len(p), len(p)-left)
+		fatal(e)
+		if left > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if compact {
+		d, e := OpenDB(dir)
+		fatal(e)
+		n, before, after, e := d.Compact()
+		saved := 0.0
+		if before > 0 {
+			saved = 100 * float64(before-after) / float64(before)
+		}
+		fmt.Printf("compacted %d files %d -> %d bytes (saved %.1f%%)\n", n, before, after, saved)
+		fatal(e)
+		return
+	}
+	if rm || set != "" {
+		if id == 0 {
+			fatal(fmt.Errorf("-rm/-set: missing -id"))
+		}
+		d, e := OpenDB(dir)
+		fatal(e)
+		if rm {
+			fatal(d.Remove(id))
+			fmt.Println("rm", id)
+		} else {
+			fatal(d.Set(id, set))
+			h, _ := FindH(d, id)
+			fmt.Println(h.String())
+		}
+		return
+	}
+	if merge != "" {
+		var a, b int64
+		if _, e := fmt.Sscanf(merge, "%d,%d", &a, &b); e != nil {
+			fatal(fmt.Errorf("-merge: expected id1,id2"))
+		}
+		d, e := OpenDB(dir)
+		fatal(e)
+		f, e := d.Merge(a, b)
+		fatal(e)
+		fmt.Println(f.Header.String())
+		return
+	}
+	if split != "" {
+		v := strings.SplitN(split, "@", 2)
+		var x int64
+		var sec float64
+		var e error
+		if len(v) == 2 {
+			if x, e = strconv.ParseInt(v[0], 10, 64); e == nil {
+				if sec, e = strconv.ParseFloat(v[1], 64); e != nil {
+					var t time.Duration
+					t, e = time.ParseDuration(v[1])
+					sec = t.Seconds()
+				}
+			}
+		}
+		if len(v) != 2 || e != nil {
+			fatal(fmt.Errorf("-split: expected id@seconds"))
+		}
+		d, e := OpenDB(dir)
+		fatal(e)
+		a, b, e := d.Split(x, sec)
+		fatal(e)
+		fmt.Println(a.Header.String())
+		fmt.Println(b.Header.String())
+		return
+	}
+	if addrace != "" || rmrace {
+		d, e := OpenDB(dir)
+		fatal(e)
+		if rmrace {
+			fatal(d.RemoveRace(id))
+			fmt.Println("rmrace", id)
+		} else {
+			r, e := ParseRace(addrace)
+			fatal(e)
+			fatal(d.AddRace(r))
+			fmt.Println("addrace", r.String())
+		}
+		return
+	}
+	if addgear != "" || rmgear != "" {
+		d, e := OpenDB(dir)
+		fatal(e)
+		if rmgear != "" {
+			fatal(d.RemoveGear(rmgear))
+			fmt.Println("rmgear", rmgear)
+		} else {
+			g, e := ParseGear(addgear)
+			fatal(e)
+			fatal(d.AddGear(g))
+			fmt.Println("addgear", g.String())
+		}
+		return
+	}
 	if unics != false {
 		fmt.Println(unix(id).Format("20060102T150405"))
 		return
 	}
 
+	if add && batch != "" {
+		d, e := OpenDB(dir)
+		fatal(e)
+		a, s, f, e := d.Batch(batch, typ, os.Stdout)
+		fatal(e)
+		fmt.Printf("imported %d, skipped %d, failed %d\n", a, s, f)
+		return
+	}
+	if strava != "" {
+		d, e := OpenDB(dir)
+		fatal(e)
+		a, s, f, e := d.ImportStrava(strava, os.Stdout)
+		fatal(e)
+		fmt.Printf("imported %d, skipped %d, failed %d\n", a, s, f)
+		return
+	}
+	if add && diary != "" {
+		d, e := OpenDB(dir)
+		fatal(e)
+		fp, e := os.Open(diary)
+		fatal(e)
+		n, errs := d.AddDiary(fp, diary)
+		fp.Close()
+		for _, e := range errs {
+			fmt.Println(e)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("imported %d\n", n)
+		return
+	}
+	if serve && flag.NArg() > 0 {
+		dbs, e := athletes(flag.Args())
+		fatal(e)
+		server(addr, dbs, watch)
+		return
+	}
+
 	var db DB
+	var meta Meta // manual entry
 	if fit != "" {
-		f, e := ReadFit(fit)
+		fs, e := ReadFit(fit)
+		fatal(e)
+		db = Files(fs)
+	} else if gpx != "" {
+		f, e := ReadGpx(gpx, typ)
 		fatal(e)
 		db = SingleFile(f)
-	} else if hdr != "" {
-		f, e := importHeader(hdr)
+	} else if tcx != "" {
+		f, e := ReadTcx(tcx, typ)
 		fatal(e)
-		fmt.Println("add", f)
 		db = SingleFile(f)
+	} else if hdr != "" {
+		f, m, e := importHeader(hdr)
+		fatal(e)
+		fmt.Println("add", strings.TrimSpace(f.Header.String()+" "+m.String()))
+		db, meta = SingleFile(f), m
 	} else {
 		var e error
 		db, e = OpenDB(dir)
@@ -77,6 +297,9 @@ func main() {
 		start, end := parseSpan(date)
 		db = FilterH(db, DateFilter(start, end))
 	}
+	if tag != "" {
+		db = FilterH(db, TagFilter(db, tag))
+	}
 	if here != "" {
 		db = Here(db, here)
 	}
@@ -86,19 +309,29 @@ func main() {
 	}
 
 	if add {
-		f, o := db.(SingleFile)
+		fs, o := db.(Files)
+		if f, single := db.(SingleFile); single {
+			fs, o = Files{File(f)}, true
+		}
 		if o == false {
 			panic("add: no single file")
 		}
 		db, e := OpenDB(dir)
 		fatal(e)
-		fatal(db.Add(File(f)))
-		fmt.Println("a", f.Start)
+		if meta.Empty() {
+			fatal(db.AddGroup(fs))
+		} else {
+			fatal(db.AddMeta(fs, map[int64]Meta{fs[0].Start: meta}))
+		}
+		for _, f := range fs {
+			fmt.Println("a", f.Start)
+		}
 	} else if list {
-		EachH(db, func(i int, h Header) { fmt.Println(h.String()) })
+		EachH(db, func(i int, h Header) { fmt.Println(headline(db, h)) })
+		GearWarnings(os.Stderr, db)
 	} else if news {
-		m := make(map[uint64]bool)
-		db = Filter(db, func(f File) bool { return f.Samples > 0 })
+		m := make(map[uint64]bool) // every point at zoom 13 in date order, the cell index (zoom 12, per track) cannot answer it
+		db = FilterH(db, func(h Header) bool { return h.Samples > 0 })
 		Each(db, func(i int, f File) {
 			u := f.WebMercator()
 			w := make([]uint64, len(u)/2)
@@ -132,8 +365,13 @@ func main() {
 		fmt.Printf("#%d %v %.0fkm %dsamples\n", n, t, km, samples)
 	} else if years {
 		Years(db)
+	} else if gear {
+		for _, g := range GearTotals(db) {
+			fmt.Println(g.String())
+		}
+		GearWarnings(os.Stderr, db)
 	} else if serve {
-		server(addr, db)
+		server(addr, []*hdb{newhdb("", db)}, watch)
 	} else if shorts > 0 {
 		Shorts(db, shorts)
 	} else if tour {
@@ -143,34 +381,39 @@ func main() {
 	}
 }
 func parseSpan(s string) (int64, int64) {
+	start, end, e := ParseSpan(s)
+	fatal(e)
+	return start, end
+}
+
+// ParseSpan parses a time span: 2021, 2021.01, 2021.02.28 or 2021.02.28-2021.03.30.
+func ParseSpan(s string) (int64, int64, error) {
 	if s == "" {
-		return 0, math.MaxInt64
+		return 0, math.MaxInt64, nil
 	}
 	if len(s) == 4 { // 2021
 		y, e := strconv.Atoi(s)
 		if e != nil {
-			fatal(fmt.Errorf("cannot parse %s", s))
+			return 0, 0, fmt.Errorf("cannot parse %s", s)
 		}
 		start := time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
 		end := start.AddDate(1, 0, 0)
-		return start.Unix(), end.Unix()
+		return start.Unix(), end.Unix(), nil
 	} else if len(s) == 7 { // 2021.01
 		start, e := time.Parse("2006.01", s)
-		fatal(e)
-		return start.Unix(), start.AddDate(0, 1, 0).Unix()
+		return start.Unix(), start.AddDate(0, 1, 0).Unix(), e
 	} else if len(s) == 10 { // 2021.02.29
 		start, e := time.Parse("2006.01.02", s)
-		fatal(e)
-		return start.Unix(), start.AddDate(0, 0, 1).Unix()
+		return start.Unix(), start.AddDate(0, 0, 1).Unix(), e
 	} else if len(s) == 21 { // 2021.02.28-2021.03.30
 		start, e := time.Parse("2006.01.02", s[:10])
-		fatal(e)
+		if e != nil {
+			return 0, 0, e
+		}
 		end, e := time.Parse("2006.01.02", s[11:])
-		fatal(e)
-		return start.Unix(), end.Unix()
+		return start.Unix(), end.Unix(), e
 	}
-	fatal(fmt.Errorf("cannot parse range: %s", s))
-	return 0, 0
+	return 0, 0, fmt.Errorf("cannot parse range: %s", s)
 }
 func fatal(e error) {
 	if e != nil {
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Meta is optional text for an activity, stored in db/meta.txt.
+// One line per field: id key value, e.g.
+//
+//	1394964105 title morning run
+//	1394964105 note felt good
+//	1394964105 tags hills race
+//	1394964105 gear trail
+//	1394964105 group 1394960000
+//	1394964105 climb 850
+type Meta struct {
+	Title string
+	Note  string
+	Tags  []string
+	Gear  string  // overrides the default from gear.txt
+	Group int64   // id of the first activity of an event (multisport)
+	Climb float64 // elevation gain (m) of manual entries
+}
+
+func (d DiskDB) metapath() string { return filepath.Join(d.dir, "meta.txt") }
+
+func ReadMeta(r io.Reader) (map[int64]Meta, error) {
+	m := make(map[int64]Meta)
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		t := strings.TrimSpace(s.Text())
+		if t == "" {
+			continue
+		}
+		v := strings.SplitN(t, " ", 3)
+		if len(v) < 2 {
+			return nil, fmt.Errorf("meta:%d: expected id key value", line)
+		}
+		id, e := strconv.ParseInt(v[0], 10, 64)
+		if e != nil {
+			return nil, fmt.Errorf("meta:%d: parse id", line)
+		}
+		if len(v) == 2 {
+			v = append(v, "")
+		}
+		x := m[id]
+		if e := x.set(v[1], v[2]); e != nil {
+			return nil, fmt.Errorf("meta:%d: %s", line, e)
+		}
+		m[id] = x
+	}
+	return m, s.Err()
+}
+func WriteMeta(w io.Writer, m map[int64]Meta) error {
+	ids := make([]int64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	b := bufio.NewWriter(w)
+	for _, id := range ids {
+		x := m[id]
+		if x.Title != "" {
+			fmt.Fprintln(b, id, "title", x.Title)
+		}
+		if x.Note != "" {
+			fmt.Fprintln(b, id, "note", x.Note)
+		}
+		if len(x.Tags) > 0 {
+			fmt.Fprintln(b, id, "tags", strings.Join(x.Tags, " "))
+		}
+		if x.Gear != "" {
+			fmt.Fprintln(b, id, "gear", x.Gear)
+		}
+		if x.Group != 0 {
+			fmt.Fprintln(b, id, "group", x.Group)
+		}
+		if x.Climb != 0 {
+			fmt.Fprintln(b, id, "climb", x.Climb)
+		}
+	}
+	return b.Flush()
+}
+
+// set changes a field. Tags are separated by space or comma.
+func (m *Meta) set(key, value string) error {
+	value = strings.Join(strings.Fields(value), " ") // single line
+	switch key {
+	case "title":
+		m.Title = value
+	case "note":
+		m.Note = value
+	case "tags":
+		m.Tags = strings.Fields(strings.Replace(value, ",", " ", -1))
+	case "gear":
+		m.Gear = value
+	case "group":
+		m.Group = 0
+		if value != "" {
+			g, e := strconv.ParseInt(value, 10, 64)
+			if e != nil {
+				return fmt.Errorf("group: parse id")
+			}
+			m.Group = g
+		}
+	case "climb":
+		m.Climb = 0
+		if value != "" {
+			c, e := strconv.ParseFloat(strings.TrimSuffix(value, "m"), 64)
+			if e != nil || c < 0 || math.IsNaN(c) || math.IsInf(c, 0) {
+				return fmt.Errorf("climb: expected meters")
+			}
+			m.Climb = c
+		}
+	default:
+		return fmt.Errorf("unknown key: %s", key)
+	}
+	return nil
+}
+func (m Meta) Empty() bool {
+	return m.Title == "" && m.Note == "" && len(m.Tags) == 0 && m.Gear == "" && m.Group == 0 && m.Climb == 0
+}
+func (m Meta) HasTag(t string) bool {
+	for _, s := range m.Tags {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+func (m Meta) String() string { // title +climb #tag..
+	s := m.Title
+	if m.Climb > 0 {
+		s += fmt.Sprintf(" +%.0fm", m.Climb)
+	}
+	for _, t := range m.Tags {
+		s += " #" + t
+	}
+	return strings.TrimSpace(s)
+}
+
+// PutMeta merges m into the metadata and rewrites meta.txt. Empty entries are removed.
+func (d *DiskDB) PutMeta(m map[int64]Meta) error {
+	all := make(map[int64]Meta)
+	for id, x := range d.meta {
+		all[id] = x
+	}
+	for id, x := range m {
+		if x.Empty() {
+			delete(all, id)
+		} else {
+			all[id] = x
+		}
+	}
+	t := d.begin()
+	if e := t.metadata(all); e != nil {
+		t.abort()
+		return e
+	}
+	if e := t.commit(); e != nil {
+		return e
+	}
+	d.meta = all
+	return nil
+}
+func (d DiskDB) Meta(id int64) Meta { return d.meta[id] }
+
+// MetaOf returns the metadata for id, if db is backed by a DiskDB.
+func MetaOf(db DB, id int64) Meta {
+	if d := disk(db); d != nil {
+		return d.Meta(id)
+	}
+	return Meta{}
+}
+
+// headline is the list output with title, tags and gear.
+func headline(db DB, h Header) string {
+	s := h.String()
+	if m := MetaOf(db, h.Start).String(); m != "" {
+		s += " " + m
+	}
+	if g := GearOf(db, h); g != "" {
+		s += " @" + g
+	}
+	return s
+}
+
+// TagFilter selects entries with tag t.
+func TagFilter(db DB, t string) func(h Header) bool {
+	return func(h Header) bool { return MetaOf(db, h.Start).HasTag(t) }
+}
+func openMeta(name string) (map[int64]Meta, error) {
+	fp, e := os.Open(name)
+	if os.IsNotExist(e) {
+		return make(map[int64]Meta), nil
+	} else if e != nil {
+		return nil, e
+	}
+	defer fp.Close()
+	m, e := ReadMeta(fp)
+	if e != nil {
+		return nil, fmt.Errorf("%s: %s", name, e)
+	}
+	return m, nil
+}
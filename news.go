@@ -1,24 +1,21 @@
 package main
 
-var News map[uint64]int64
-
-func makenews(db DB) {
+func makenews(db DB) (News map[uint64]int64) {
 	News = make(map[uint64]int64) // level-13 dot cache
-	Each(db, func(i int, f File) {
-		u := f.WebMercator()
-		w := make([]uint64, len(u)/2)
-		for j := 0; j < len(u); j += 2 {
-			w[j/2] = uint64(u[j]>>11)<<32 | uint64(u[1+j]>>11)
-		}
-		for _, h := range w {
-			if News[h] == 0 {
-				News[h] = f.Start // mark file start as first step on that point
-			}
+	Each(db, func(i int, f File) { addnews(News, f) })
+	return News
+}
+func addnews(News map[uint64]int64, f File) {
+	u := f.WebMercator()
+	for j := 0; j < len(u); j += 2 {
+		h := uint64(u[j]>>11)<<32 | uint64(u[1+j]>>11)
+		if s := News[h]; s == 0 || s > f.Start {
+			News[h] = f.Start // mark file start as first step on that point
 		}
-	})
+	}
 }
 
-func getnews(f File) []int8 {
+func getnews(News map[uint64]int64, f File) []int8 {
 	u := f.WebMercator()
 	w := make([]uint64, len(u)/2)
 	for j := 0; j < len(u); j += 2 {
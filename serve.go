@@ -13,62 +13,130 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 //go:embed www
 var www embed.FS
 
-var db hdb
 var root fs.FS
-var tile Tile
 
+// hdb is the db of one athlete with the server caches.
 type hdb struct {
 	sync.Mutex
 	DB
-	cal Cal
+	name string // url prefix (empty: single db at /)
+	cal  Cal
+	tile Tile
+	news map[uint64]int64
 }
 
-func server(addr string, a DB) {
-	db = hdb{DB: a, cal: Calendar(a)}
-	tile = NewTile(db)
-	makenews(db)
-	fmt.Println(addr+"/index.html", len(tile.run)+len(tile.bike))
+func newhdb(name string, a DB) *hdb {
+	return &hdb{DB: a, name: name, cal: Calendar(a), tile: NewTile(a), news: makenews(a)}
+}
 
+// server serves a single db at / or several athletes at /name/ with a landing page at /.
+// With watch, new track files in watch (or watch/name/) are added.
+func server(addr string, dbs []*hdb, watch string) {
 	var e error
 	root, e = fs.Sub(www, "www")
 	fatal(e)
-	http.Handle("/", http.FileServer(http.FS(root)))
 	template.ParseFS(www, "*.tmpl")
-	http.HandleFunc("/index.html", serveIndex)
-	http.HandleFunc("/strip.png", serveStrip)
-	http.HandleFunc("/vd", serveVd)
-	http.HandleFunc("/vd.png", serveVdPng)
-	http.HandleFunc("/cal", serveCal)
-	http.HandleFunc("/list", serveList)
-	http.HandleFunc("/race", serveRace)
-	http.HandleFunc("/head", serveHead)
-	http.HandleFunc("/json", serveJson)
-	http.HandleFunc("/alt", serveAlt)
-	http.HandleFunc("/ll", serveLatLon)
-	http.HandleFunc("/next", serveNext)
-	http.HandleFunc("/tile/", serveTile)
+	if len(dbs) == 1 && dbs[0].name == "" {
+		http.Handle("/", dbs[0].handler())
+	} else {
+		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { serveHome(w, r, dbs) })
+		for _, db := range dbs {
+			http.Handle("/"+db.name+"/", http.StripPrefix("/"+db.name, db.handler()))
+		}
+	}
+	for _, db := range dbs {
+		prefix := ""
+		if db.name != "" {
+			prefix = "/" + db.name
+		}
+		fmt.Println(addr+prefix+"/index.html", db.tile.points())
+		if watch != "" {
+			go db.watch(filepath.Join(watch, db.name), 5*time.Second)
+		}
+	}
 	fatal(http.ListenAndServe(addr, nil))
 }
+
+// athletes opens the db dirs for -serve name=dir.. (or dir.., the name is the last path element).
+func athletes(args []string) (dbs []*hdb, e error) {
+	names := make(map[string]bool)
+	for _, a := range args {
+		name, dir := filepath.Base(a), a
+		if v := strings.SplitN(a, "=", 2); len(v) == 2 {
+			name, dir = v[0], v[1]
+		}
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/?#") || names[name] {
+			return nil, fmt.Errorf("athlete: bad or duplicate name: %q", name)
+		}
+		names[name] = true
+		d, e := OpenDB(dir)
+		if e != nil {
+			return nil, e
+		}
+		dbs = append(dbs, newhdb(name, d))
+	}
+	return dbs, nil
+}
+func (db *hdb) handler() http.Handler {
+	m := http.NewServeMux()
+	m.Handle("/", http.FileServer(http.FS(root)))
+	m.HandleFunc("/index.html", db.serveIndex)
+	m.HandleFunc("/strip.png", db.serveStrip)
+	m.HandleFunc("/vd", db.serveVd)
+	m.HandleFunc("/vd.png", db.serveVdPng)
+	m.HandleFunc("/cal", db.serveCal)
+	m.HandleFunc("/list", db.serveList)
+	m.HandleFunc("/race", db.serveRace)
+	m.HandleFunc("/head", db.serveHead)
+	m.HandleFunc("/json", db.serveJson)
+	m.HandleFunc("/laps", db.serveLaps)
+	m.HandleFunc("/alt", db.serveAlt)
+	m.HandleFunc("/ll", db.serveLatLon)
+	m.HandleFunc("/next", db.serveNext)
+	m.HandleFunc("/tile/", db.serveTile)
+	m.HandleFunc("/upload", db.serveUpload)
+	return m
+}
 func templ(w io.Writer, file string, data interface{}) {
 	template.Must(template.ParseFS(root, file)).Execute(w, data)
 }
 
-func serveIndex(w http.ResponseWriter, r *http.Request) {
+func (db *hdb) serveIndex(w http.ResponseWriter, r *http.Request) {
 	db.Lock()
 	defer db.Unlock()
 	n, t, km, samples := Totals(db)
 	totals := fmt.Sprintf("#%d %v %.0fkm %dsamples\n", n, t, km, samples)
-	templ(w, "index.tmpl", totals)
+	templ(w, "index.tmpl", struct {
+		Name, Totals string
+		Gear         []GearTotal
+	}{db.name, totals, GearTotals(db.DB)})
 }
-func serveCal(w http.ResponseWriter, r *http.Request) {
+func serveHome(w http.ResponseWriter, r *http.Request, dbs []*hdb) { // athletes
+	if r.URL.Path != "/" && r.URL.Path != "/index.html" {
+		http.FileServer(http.FS(root)).ServeHTTP(w, r)
+		return
+	}
+	type t struct{ Name, Totals string }
+	var a []t
+	for _, db := range dbs {
+		db.Lock()
+		n, d, km, _ := Totals(db)
+		db.Unlock()
+		a = append(a, t{db.name, fmt.Sprintf("#%d %v %.0fkm", n, d, km)})
+	}
+	templ(w, "home.tmpl", a)
+}
+func (db *hdb) serveCal(w http.ResponseWriter, r *http.Request) {
 	db.Lock()
 	defer db.Unlock()
 	wk := -1
@@ -77,37 +145,42 @@ func serveCal(w http.ResponseWriter, r *http.Request) {
 	}
 	db.cal.Write(w, true, wk)
 }
-func serveList(w http.ResponseWriter, r *http.Request) {
+func (db *hdb) serveList(w http.ResponseWriter, r *http.Request) {
 	db.Lock()
 	defer db.Unlock()
 	var d DB = db
-	if g := getRect(r); g != nil {
-		d = Filter(db, g)
+	if g, n, s, w, e := getRect(r); g != nil {
+		d = Filter(Near(db.DB, n, s, w, e), g)
+	}
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		d = FilterH(d, TagFilter(db.DB, tag))
 	}
 	tile := r.URL.Query().Get("tile")
 	type t struct {
-		Id      int64
+		Id, Map int64
 		Tile, S string
 	}
 	var heads []t
-	EachH(d, func(i int, h Header) { heads = append(heads, t{h.Start, tile, h.String()[11:]}) })
+	EachH(d, func(i int, h Header) {
+		heads = append(heads, t{h.Start, h.Start, tile, headline(db.DB, h)[11:]})
+	})
 	templ(w, "list.tmpl", heads)
 }
-func serveRace(w http.ResponseWriter, r *http.Request) {
+func (db *hdb) serveRace(w http.ResponseWriter, r *http.Request) {
 	db.Lock()
 	defer db.Unlock()
 	tile := r.URL.Query().Get("tile")
 	type t struct {
-		Id      int64
+		Id, Map int64 // Map: linked activity
 		Tile, S string
 	}
 	var heads []t
 	for _, r := range db.Races() {
-		heads = append(heads, t{r.Start, tile, r.String()})
+		heads = append(heads, t{r.Start, r.Id, tile, r.String()})
 	}
 	templ(w, "list.tmpl", heads)
 }
-func getRect(r *http.Request) func(f File) bool {
+func getRect(r *http.Request) (g func(f File) bool, n, s, w, e float64) {
 	p := func(s string) float64 {
 		n, e := strconv.ParseFloat(s, 64)
 		if e != nil {
@@ -116,9 +189,9 @@ func getRect(r *http.Request) func(f File) bool {
 		return n
 	}
 	q := r.URL.Query()
-	n, s, w, e := p(q.Get("n")), p(q.Get("s")), p(q.Get("w")), p(q.Get("e"))
+	n, s, w, e = p(q.Get("n")), p(q.Get("s")), p(q.Get("w")), p(q.Get("e"))
 	if math.IsNaN(n) || math.IsNaN(s) || math.IsNaN(w) || math.IsNaN(e) {
-		return nil
+		return nil, n, s, w, e
 	}
 	return func(f File) bool {
 		for i := uint64(0); i < f.Samples; i++ {
@@ -128,7 +201,7 @@ func getRect(r *http.Request) func(f File) bool {
 			}
 		}
 		return false
-	}
+	}, n, s, w, e
 }
 func pa(r *http.Request, p string) string {
 	v := r.URL.Query().Get(p)
@@ -145,7 +218,7 @@ func getId(r *http.Request) int64 {
 	}
 	return id
 }
-func getHeader(r *http.Request) (Header, error) {
+func (db *hdb) getHeader(r *http.Request) (Header, error) {
 	db.Lock()
 	defer db.Unlock()
 	h, e := FindH(db, getId(r))
@@ -154,7 +227,7 @@ func getHeader(r *http.Request) (Header, error) {
 	}
 	return h, e
 }
-func getFile(r *http.Request) (File, error) {
+func (db *hdb) getFile(r *http.Request) (File, error) {
 	db.Lock()
 	defer db.Unlock()
 	f, e := Find(db, getId(r))
@@ -163,21 +236,76 @@ func getFile(r *http.Request) (File, error) {
 	}
 	return f, e
 }
-func serveHead(w http.ResponseWriter, r *http.Request) {
-	h, e := getHeader(r)
+func (db *hdb) serveHead(w http.ResponseWriter, r *http.Request) {
+	h, e := db.getHeader(r)
 	if e == nil {
-		fmt.Fprintln(w, h.String())
+		db.Lock()
+		s, m := headline(db.DB, h), MetaOf(db.DB, h.Start)
+		db.Unlock()
+		fmt.Fprintln(w, s)
+		if m.Note != "" {
+			fmt.Fprintln(w, m.Note)
+		}
 	}
 }
-func serveJson(w http.ResponseWriter, r *http.Request) {
-	f, e := getFile(r)
+func (db *hdb) serveJson(w http.ResponseWriter, r *http.Request) {
+	f, e := db.getFile(r)
 	if e == nil {
-		json.NewEncoder(w).Encode(f)
+		json.NewEncoder(w).Encode(struct {
+			File
+			Time, Dist, Alt, Speed nullf32
+		}{f, f.Time, f.Dist, f.Alt, f.Speed})
+	}
+}
+
+type nullf32 []float32 // json: NaN (invalid) as null
+
+func (x nullf32) MarshalJSON() ([]byte, error) {
+	if x == nil {
+		return []byte("null"), nil
 	}
+	b := []byte{'['}
+	for i, v := range x {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			b = append(b, "null"...)
+		} else {
+			b = strconv.AppendFloat(b, float64(v), 'g', -1, 32)
+		}
+	}
+	return append(b, ']'), nil
 }
-func serveAlt(w http.ResponseWriter, r *http.Request) {
+func (db *hdb) serveLaps(w http.ResponseWriter, r *http.Request) {
+	f, e := db.getFile(r)
+	if e != nil {
+		return
+	}
+	type lap struct {
+		Lap
+		Name string    // trigger
+		P    []float64 `json:",omitempty"` // lat, lon at lap start
+	}
+	laps := make([]lap, len(f.Laps))
+	k := 0
+	for i, l := range f.Laps {
+		laps[i] = lap{Lap: l, Name: l.TriggerName()}
+		for k < int(f.Samples) && f.Time[k] < l.Start {
+			k++
+		}
+		for j := k; j < int(f.Samples); j++ { // first valid position
+			if la, lo := Deg(f.Lat[j]), Deg(f.Lon[j]); !math.IsNaN(la) && !math.IsNaN(lo) {
+				laps[i].P = []float64{la, lo}
+				break
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(laps)
+}
+func (db *hdb) serveAlt(w http.ResponseWriter, r *http.Request) {
 	W, H := 600, 50
-	f, e := getFile(r)
+	f, e := db.getFile(r)
 	if e == nil && f.Samples > 0 {
 		w.Header().Set("Content-Type", "image/png")
 		m := image.NewRGBA(image.Rect(0, 0, W, H))
@@ -207,8 +335,8 @@ func serveAlt(w http.ResponseWriter, r *http.Request) {
 		png.Encode(w, m)
 	}
 }
-func serveLatLon(w http.ResponseWriter, r *http.Request) {
-	f, e := getFile(r)
+func (db *hdb) serveLatLon(w http.ResponseWriter, r *http.Request) {
+	f, e := db.getFile(r)
 	if e == nil {
 		p := make([][2]float64, 0, f.Samples)
 		for i := uint64(0); i < f.Samples; i++ {
@@ -217,13 +345,15 @@ func serveLatLon(w http.ResponseWriter, r *http.Request) {
 				p = append(p, [2]float64{la, lo})
 			}
 		}
+		db.Lock()
 		d := struct {
 			P [][2]float64
 			N []int8
 		}{
 			P: p,
-			N: getnews(f),
+			N: getnews(db.news, f),
 		}
+		db.Unlock()
 		if e := json.NewEncoder(w).Encode(d); e != nil {
 			fmt.Println("ll", e)
 		}
@@ -231,12 +361,12 @@ func serveLatLon(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("ll", e)
 	}
 }
-func serveNext(w http.ResponseWriter, r *http.Request) {
+func (db *hdb) serveNext(w http.ResponseWriter, r *http.Request) {
 	db.Lock()
 	defer db.Unlock()
 	fmt.Fprintf(w, "%d", NextId(db, getId(r), r.URL.Query().Get("prev") == "true"))
 }
-func serveTile(w http.ResponseWriter, r *http.Request) {
+func (db *hdb) serveTile(w http.ResponseWriter, r *http.Request) {
 	v := strings.Split(r.URL.Path, "/") // /tile/grey/11/1023/234.png or /tile/points/$z/$x/$y.png
 	if len(v) != 6 {
 		fmt.Println("tile: wrong path:", r.URL.Path)
@@ -251,9 +381,12 @@ func serveTile(w http.ResponseWriter, r *http.Request) {
 	v[5] = strings.TrimSuffix(v[5], ".png")
 
 	w.Header().Set("Content-Type", "image/png")
-	tile.Png(w, p(v[3]), p(v[4]), p(v[5]), v[2])
+	db.Lock()
+	t := Tile{append([][]uint32{}, db.tile.u...)} // points are only appended
+	db.Unlock()
+	t.Png(w, p(v[3]), p(v[4]), p(v[5]), v[2])
 }
-func serveStrip(w http.ResponseWriter, r *http.Request) {
+func (db *hdb) serveStrip(w http.ResponseWriter, r *http.Request) {
 	db.Lock()
 	defer db.Unlock()
 	w.Header().Set("Content-Type", "image/png")
@@ -261,7 +394,7 @@ func serveStrip(w http.ResponseWriter, r *http.Request) {
 		fmt.Println(e)
 	}
 }
-func serveVd(w http.ResponseWriter, r *http.Request) {
+func (db *hdb) serveVd(w http.ResponseWriter, r *http.Request) {
 	db.Lock()
 	defer db.Unlock()
 	atoi := func(s string) int { r, _ := strconv.Atoi(s); return r }
@@ -278,7 +411,7 @@ func serveVd(w http.ResponseWriter, r *http.Request) {
 	})
 	http.Redirect(w, r, "map.html?id="+strconv.FormatInt(hmin.Start, 10)+pa(r, "tile"), 301)
 }
-func serveVdPng(w http.ResponseWriter, r *http.Request) { // velocity-over-speed map
+func (db *hdb) serveVdPng(w http.ResponseWriter, r *http.Request) { // velocity-over-speed map
 	db.Lock()
 	defer db.Unlock()
 	w.Header().Set("Content-Type", "image/png")
@@ -292,14 +425,7 @@ func serveVdPng(w http.ResponseWriter, r *http.Request) { // velocity-over-speed
 	w.Header().Set("Content-Type", "image/png")
 	EachH(db, func(i int, h Header) {
 		x, y := int(h.Meters/1000), height-int(25*h.Meters/h.Seconds)
-		switch h.Type {
-		case 1:
-			m.SetRGBA(x, y, red)
-		case 2:
-			m.SetRGBA(x, y, green)
-		case 5:
-			m.SetRGBA(x, y, blue)
-		}
+		m.SetRGBA(x, y, sports[sportOf(h.Type)].Color)
 	})
 	w.Header().Set("Content-Type", "image/png")
 	if e := png.Encode(w, m); e != nil {
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tormoder/fit"
+)
+
+// Sport is the letter and colour of a FIT sport or a sport/sub-sport pair.
+// Header.Type holds the FIT sport in the low byte and the sub-sport above it,
+// e.g. 1538 (2|6<<8) is cycling/indoor_cycling.
+// Sub-sports use the entry of their sport unless they have their own.
+//
+// The defaults can be changed in db/sport.txt, one per line: type letter colour (letters are unique)
+//
+//	2 B 00e673
+//	2.6 Y 80c0a0
+type Sport struct {
+	Type   uint32
+	Letter byte
+	Color  color.RGBA
+}
+
+var (
+	brown  = color.RGBA{150, 100, 40, 255}
+	cyan   = color.RGBA{0, 170, 220, 255}
+	water  = color.RGBA{0, 140, 140, 255}
+	purple = color.RGBA{140, 70, 170, 255}
+	grey   = color.RGBA{128, 128, 128, 255}
+)
+
+// sports is ordered by sport and sub-sport (see order), calendar bars and tiles draw them in reverse order.
+var sports = []Sport{
+	{0, 'G', grey}, // generic
+	{1, 'R', red},
+	{2, 'B', green},
+	{3, 'T', grey}, // transition
+	{4, 'F', purple},
+	{5, 'S', blue},
+	{6, 'b', grey},
+	{7, 'o', grey},
+	{8, 't', grey},
+	{9, 'a', grey},
+	{10, 'X', purple}, // training
+	{11, 'W', brown},
+	{12, 'N', cyan}, // nordic (cross country skiing)
+	{13, 'A', cyan},
+	{14, 'n', cyan},
+	{15, 'O', water}, // rowing
+	{16, 'M', brown},
+	{17, 'H', brown},
+	{18, 'Z', grey}, // multisport
+	{19, 'P', water},
+	{20, 'f', grey},
+	{21, 'E', green},
+	{22, 'm', grey},
+	{23, 'y', water},
+	{24, 'd', grey},
+	{25, 'g', grey},
+	{26, 'h', grey},
+	{27, 'e', grey}, // equestrian
+	{28, 'u', grey},
+	{29, 'i', grey},
+	{30, 'L', grey},
+	{31, 'C', brown},
+	{32, 's', water},
+	{33, 'I', cyan},
+	{34, 'j', grey},
+	{35, 'w', cyan},
+	{36, 'v', grey},
+	{37, 'U', water},
+	{38, 'c', water},
+	{39, 'k', water},
+	{40, 'x', water},
+	{41, 'K', water},
+	{42, 'r', water},
+	{43, 'q', water},
+	{44, 'z', water},
+	{45, 'l', grey},
+	{46, 'J', grey},
+	{47, 'p', grey},
+	{48, 'V', grey},
+	{53, 'D', water},
+	{255, '?', grey}, // unknown
+}
+
+// sportAlias maps common names of other formats (gpx, tcx, strava) to sport types.
+var sportAlias = map[string]uint32{
+	"run": 1, "biking": 2, "bike": 2, "ride": 2, "swim": 5, "walk": 11, "hike": 17,
+	"virtualrun": 1 | 45<<8, "virtualride": 2 | 6<<8, "nordicski": 12, "alpineski": 13,
+	"snowboard": 14, "rowing": 15, "kayaking": 41, "rockclimbing": 31, "iceskate": 33,
+	"inlineskate": 30, "snowshoe": 35, "standuppaddling": 37, "surfing": 38,
+	"weighttraining": 10 | 20<<8, "workout": 10, "yoga": 10 | 43<<8, "ebikeride": 21, "other": 0,
+	"rockclimb": 31, "canoe": 19, "backcountryski": 13 | 37<<8, "crossfit": 10, "elliptical": 4 | 15<<8,
+	"stairstepper": 4 | 16<<8, "rollerski": 12, "windsurf": 43, "kitesurf": 44, "handcycle": 2 | 12<<8, "velomobile": 2,
+}
+
+// sportOf returns the index in sports for type t.
+func sportOf(t uint32) int {
+	u := -1
+	for i, s := range sports {
+		if s.Type == t {
+			return i
+		} else if s.Type == t&0xff || (u < 0 && s.Type == 255) {
+			u = i
+		}
+	}
+	return u
+}
+func sport(t uint32) byte   { return sports[sportOf(t)].Letter }
+func order(t uint32) uint32 { return (t&0xff)<<8 | t>>8 } // sub-sports follow their sport
+
+// sportName is the FIT name, e.g. "running" or "cycling/indoor_cycling".
+func sportName(t uint32) string {
+	s := snake(fit.Sport(t & 0xff).String())
+	if sub := fit.SubSport(t >> 8); sub != 0 {
+		s += "/" + snake(sub.String())
+	}
+	return s
+}
+func snake(s string) string { // IndoorCycling: indoor_cycling
+	var b strings.Builder
+	for i, c := range s {
+		if c >= 'A' && c <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			c += 'a' - 'A'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// parseSport accepts a letter, a name (hiking, cycling/indoor_cycling, run) or a number (2, 2.6).
+func parseSport(s string) (uint32, error) {
+	for _, x := range sports {
+		if s == string(x.Letter) {
+			return x.Type, nil
+		}
+	}
+	if t, o := sportAlias[strings.ToLower(s)]; o {
+		return t, nil
+	}
+	for t := uint32(0); t < 256; t++ {
+		if s == sportName(t) {
+			return t, nil
+		}
+		if v := strings.SplitN(s, "/", 2); len(v) == 2 && v[0] == sportName(t) {
+			for u := uint32(1); u < 255; u++ {
+				if v[1] == snake(fit.SubSport(u).String()) {
+					return t | u<<8, nil
+				}
+			}
+		}
+	}
+	v := strings.SplitN(s, ".", 2)
+	t, e := strconv.ParseUint(v[0], 10, 8)
+	var u uint64
+	if e == nil && len(v) == 2 {
+		u, e = strconv.ParseUint(v[1], 10, 8)
+	}
+	if e != nil {
+		return 0, fmt.Errorf("unknown sport: %s", s)
+	}
+	return uint32(t | u<<8), nil
+}
+
+// readSports changes the sports table from the file, if it exists.
+func readSports(name string) error {
+	fp, e := os.Open(name)
+	if os.IsNotExist(e) {
+		return nil
+	} else if e != nil {
+		return e
+	}
+	defer fp.Close()
+	s := bufio.NewScanner(fp)
+	line := 0
+	lines := make(map[uint32]int) // types set by the file
+	for s.Scan() {
+		line++
+		v := strings.Fields(s.Text())
+		if len(v) == 0 {
+			continue
+		}
+		err := func(s string) error { return fmt.Errorf("%s:%d: %s", name, line, s) }
+		if len(v) != 3 {
+			return err("expected: type letter colour")
+		}
+		t, e := parseSport(v[0])
+		if e != nil || strings.Contains(v[0], ".") == false && t > 255 {
+			return err("type")
+		}
+		if c := v[1]; len(c) != 1 || !(c[0] >= 'A' && c[0] <= 'Z' || c[0] >= 'a' && c[0] <= 'z' || c[0] >= '0' && c[0] <= '9' || c[0] == '?') {
+			return err("letter must be a single letter or digit")
+		}
+		rgb, e := strconv.ParseUint(strings.TrimPrefix(v[2], "#"), 16, 32)
+		if e != nil || len(strings.TrimPrefix(v[2], "#")) != 6 {
+			return err("colour must be hex rrggbb")
+		}
+		x := Sport{t, v[1][0], color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 255}}
+		lines[t] = line
+		if i := sportOf(t); sports[i].Type == t {
+			sports[i] = x
+		} else {
+			k := sort.Search(len(sports), func(k int) bool { return order(sports[k].Type) > order(t) })
+			sports = append(sports[:k], append([]Sport{x}, sports[k:]...)...)
+		}
+	}
+	if e := s.Err(); e != nil {
+		return e
+	}
+	letter := make(map[byte]uint32) // parseSport needs unique letters, checked after the file such that letters can be swapped
+	for _, x := range sports {
+		if t, o := letter[x.Letter]; o {
+			if lines[t] > lines[x.Type] {
+				t, x.Type = x.Type, t
+			}
+			return fmt.Errorf("%s:%d: letter %c is also used by %s", name, lines[x.Type], x.Letter, sportName(t))
+		}
+		letter[x.Letter] = x.Type
+	}
+	return nil
+}
+func (s Sport) String() string {
+	return fmt.Sprintf("%c %02x%02x%02x %d %s", s.Letter, s.Color.R, s.Color.G, s.Color.B, s.Type, sportName(s.Type))
+}
+
+func sportCSS() (s string) { // calendar classes s0, s1.. by index in sports
+	for i, x := range sports {
+		s += fmt.Sprintf(" .s%d{color:#%02x%02x%02x}\n", i, x.Color.R, x.Color.G, x.Color.B)
+	}
+	return s
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportStrava adds the activities of a strava bulk export: a zip with activities.csv and
+// activities/*.fit.gz, *.gpx.gz and *.tcx.gz. The activity name and description become title and note,
+// the activity type sets the sport. Activities without a track file are added as header-only entries
+// from the date, elapsed time and distance columns. Existing ids (also ±1h) are skipped.
+// Decoded activities are added in chunks of about 500 files, one transaction each.
+// Like Batch, it writes one line per file and counts activities.
+func (d *DiskDB) ImportStrava(archive string, w io.Writer) (added, skipped, failed int, e error) {
+	z, e := zip.OpenReader(archive)
+	if e != nil {
+		return 0, 0, 0, e
+	}
+	defer z.Close()
+	files := make(map[string]*zip.File)
+	var index *zip.File
+	for _, f := range z.File {
+		files[f.Name] = f
+		if path.Base(f.Name) == "activities.csv" && (index == nil || len(f.Name) < len(index.Name)) {
+			index = f
+		}
+	}
+	if index == nil {
+		return 0, 0, 0, fmt.Errorf("%s: no activities.csv", archive)
+	}
+	dir := path.Dir(index.Name) // names in the csv are relative to it
+	b, e := unzip(index)
+	if e != nil {
+		return 0, 0, 0, e
+	}
+	c := csv.NewReader(strings.NewReader(string(b)))
+	c.FieldsPerRecord = -1
+	rows, e := c.ReadAll()
+	if e != nil {
+		return 0, 0, 0, fmt.Errorf("%s: %s", index.Name, e)
+	} else if len(rows) == 0 {
+		return 0, 0, 0, fmt.Errorf("%s: empty", index.Name)
+	}
+	col := make(map[string]int)
+	for i, s := range rows[0] {
+		if _, o := col[s]; !o {
+			col[s] = i
+		}
+	}
+	for _, s := range []string{"Activity ID", "Activity Name", "Activity Type", "Filename"} {
+		if _, o := col[s]; !o {
+			return 0, 0, 0, fmt.Errorf("%s: missing column: %s", index.Name, s)
+		}
+	}
+	get := func(row []string, s string) string {
+		if i, o := col[s]; o && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	dist, meters := -1, false // a second Distance column is in meters
+	if i, o := col["Distance"]; o {
+		dist = i
+	}
+	for i := len(rows[0]) - 1; dist >= 0 && i > dist; i-- {
+		if rows[0][i] == "Distance" {
+			dist, meters = i, true
+			break
+		}
+	}
+	var fs []File
+	var names []string // per file
+	n := 0             // activities in fs
+	meta := make(map[int64]Meta)
+	seen := make(map[int64]bool)
+	flush := func() { // one transaction per chunk
+		if e := d.AddMeta(fs, meta); e != nil {
+			for _, name := range names {
+				fmt.Fprintf(w, "fail %s: %s\n", name, e)
+			}
+			failed += n
+		} else {
+			for i, f := range fs {
+				fmt.Fprintf(w, "a %d %s\n", f.Start, names[i])
+			}
+			added += n
+		}
+		fs, names, meta, n = nil, nil, make(map[int64]Meta), 0
+	}
+	for _, row := range rows[1:] {
+		name := get(row, "Filename")
+		sport := stravaSport(get(row, "Activity Type"))
+		var a []File
+		var e error
+		if name == "" {
+			name = "activity " + get(row, "Activity ID")
+			km := ""
+			if dist >= 0 && dist < len(row) {
+				km = strings.TrimSpace(row[dist])
+			}
+			a, e = stravaManual(get(row, "Activity Date"), sport, get(row, "Elapsed Time"), km, meters)
+		} else {
+			a, e = readStrava(files[path.Join(dir, name)], name, sport)
+		}
+		if e == nil && a[0].Start == 0 {
+			e = fmt.Errorf("no start time")
+		}
+		if e != nil {
+			fmt.Fprintf(w, "fail %s: %s\n", name, e)
+			failed++
+			continue
+		} else if id := a[0].Start; d.has(id) || seen[id] || seen[id-3600] || seen[id+3600] {
+			fmt.Fprintf(w, "skip %s: %d exists\n", name, id)
+			skipped++
+			continue
+		}
+		for i, f := range a {
+			var m Meta
+			if len(a) > 1 {
+				m.Group = a[0].Start
+			}
+			if i == 0 {
+				m.set("title", get(row, "Activity Name"))
+				m.set("note", get(row, "Activity Description"))
+			}
+			meta[f.Start] = m
+			seen[f.Start] = true
+			names = append(names, name)
+		}
+		n++
+		if fs = append(fs, a...); len(fs) >= 500 {
+			flush()
+		}
+	}
+	if len(fs) > 0 {
+		flush()
+	}
+	return added, skipped, failed, nil
+}
+
+// stravaManual is a header-only entry for an activity without a track file.
+// The date is utc, e.g. "Aug 15, 2015, 2:45:08 PM", the elapsed time in seconds and the distance in km or meters.
+func stravaManual(date, sport, elapsed, dist string, meters bool) ([]File, error) {
+	t, e := time.Parse("Jan 2, 2006, 3:04:05 PM", date)
+	if e != nil {
+		return nil, fmt.Errorf("activity date: %s", date)
+	}
+	sec, e := strconv.ParseFloat(elapsed, 64)
+	if e != nil {
+		return nil, fmt.Errorf("elapsed time: %s", elapsed)
+	}
+	m := 0.0
+	if dist != "" {
+		if m, e = strconv.ParseFloat(strings.Replace(dist, ",", "", -1), 64); e != nil {
+			return nil, fmt.Errorf("distance: %s", dist)
+		}
+	}
+	if !meters {
+		m *= 1000
+	}
+	typ, _ := parseSport(sport) // unknown: generic
+	return []File{{Header: Header{Start: t.Unix(), Type: typ, Seconds: float32(sec), Meters: float32(m), Zone: noZone}}}, nil
+}
+
+// readStrava decodes a track file of the archive. The strava sport replaces the sport of a fit file,
+// unless they agree on the FIT sport (the file may have a sub-sport).
+func readStrava(f *zip.File, name, sport string) ([]File, error) {
+	if f == nil {
+		return nil, fmt.Errorf("not in the archive")
+	}
+	b, e := unzip(f)
+	if e != nil {
+		return nil, e
+	}
+	if strings.HasSuffix(name, ".gz") {
+		r, e := gzip.NewReader(strings.NewReader(string(b)))
+		if e != nil {
+			return nil, e
+		}
+		if b, e = ioutil.ReadAll(io.LimitReader(r, maxTrack)); e != nil {
+			return nil, e
+		}
+		name = strings.TrimSuffix(name, ".gz")
+	}
+	fs, e := readTrack(b, name, sport)
+	if e != nil || sport == "" || len(fs) != 1 || strings.HasSuffix(strings.ToLower(name), ".fit") == false {
+		return fs, e
+	}
+	if t, _ := parseSport(sport); t&0xff != fs[0].Type&0xff {
+		fs[0].Type = t
+	}
+	return fs, nil
+}
+
+const maxTrack = 256 << 20 // uncompressed
+
+func unzip(f *zip.File) ([]byte, error) {
+	r, e := f.Open()
+	if e != nil {
+		return nil, e
+	}
+	defer r.Close()
+	return ioutil.ReadAll(io.LimitReader(r, maxTrack))
+}
+
+// stravaSport returns the alias of a strava activity type (Run, Virtual Ride, E-Bike Ride), or "" if it is unknown.
+func stravaSport(s string) string {
+	s = strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(s))
+	if _, o := sportAlias[s]; o {
+		return s
+	}
+	return ""
+}
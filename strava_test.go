@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestStravaManual(t *testing.T) {
+	for _, c := range []struct {
+		date, sport, elapsed, dist string
+		meters                     bool
+		h                          Header // Start 0: error
+	}{
+		{"Aug 15, 2015, 2:45:08 PM", "run", "2832", "9.01", false, Header{Start: 1439649908, Type: 1, Seconds: 2832, Meters: 9010}},
+		{"Aug 15, 2015, 2:45:08 PM", "run", "2832", "9010.5", true, Header{Start: 1439649908, Type: 1, Seconds: 2832, Meters: 9010.5}},
+		{"Jan 2, 2020, 12:00:00 AM", "", "60", "", false, Header{Start: 1577923200, Seconds: 60}},
+		{"Jan 2, 2020, 12:00:00 AM", "virtualride", "60", "1,234.5", true, Header{Start: 1577923200, Type: 2 | 6<<8, Seconds: 60, Meters: 1234.5}},
+		{"2015-08-15 14:45:08", "run", "2832", "9.01", false, Header{}},
+		{"Aug 15, 2015, 2:45:08 PM", "run", "47m", "9.01", false, Header{}},
+		{"Aug 15, 2015, 2:45:08 PM", "run", "2832", "9 km", false, Header{}},
+	} {
+		fs, e := stravaManual(c.date, c.sport, c.elapsed, c.dist, c.meters)
+		if c.h.Start == 0 {
+			if e == nil {
+				t.Errorf("%v: expected an error", c)
+			}
+			continue
+		}
+		c.h.Zone = noZone
+		if e != nil || len(fs) != 1 || fs[0].Header != c.h {
+			t.Errorf("%v: got %v %v, expected %+v", c, fs, e, c.h)
+		}
+	}
+}
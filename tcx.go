@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tormoder/fit"
+)
+
+type tcx struct {
+	Activity []struct {
+		Sport string `xml:"Sport,attr"`
+		Id    string `xml:"Id"`
+		Lap   []struct {
+			StartTime string  `xml:"StartTime,attr"`
+			Seconds   float64 `xml:"TotalTimeSeconds"`
+			Meters    float64 `xml:"DistanceMeters"`
+			Trigger   string  `xml:"TriggerMethod"`
+			Pt        []struct {
+				Time  string   `xml:"Time"`
+				Lat   *float64 `xml:"Position>LatitudeDegrees"`
+				Lon   *float64 `xml:"Position>LongitudeDegrees"`
+				Alt   *float64 `xml:"AltitudeMeters"`
+				Dist  *float64 `xml:"DistanceMeters"`
+				Hr    uint16   `xml:"HeartRateBpm>Value"`
+				Cad   *uint16  `xml:"Cadence"`
+				Speed *float64 `xml:"Extensions>TPX>Speed"`
+				Watts *uint16  `xml:"Extensions>TPX>Watts"`
+			} `xml:"Track>Trackpoint"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+// ReadTcx reads the first activity of a tcx file with laps, track points and heart rate.
+// The sport is taken from the Sport attribute (Running, Biking, Other), unless sport is given.
+// Missing distances are computed from the coordinates.
+func ReadTcx(file, sport string) (File, error) {
+	fp, e := os.Open(file)
+	if e != nil {
+		return File{}, e
+	}
+	defer fp.Close()
+	return readTcx(fp, file, sport)
+}
+func readTcx(r io.Reader, file, sport string) (f File, e error) {
+	var x tcx
+	if e := xml.NewDecoder(r).Decode(&x); e != nil {
+		return f, fmt.Errorf("%s: %s", file, e)
+	}
+	if len(x.Activity) == 0 {
+		return f, fmt.Errorf("%s: no activity", file)
+	}
+	a := x.Activity[0]
+	if sport == "" {
+		sport = strings.ToLower(a.Sport)
+	}
+	typ, e := parseSport(sport)
+	if e != nil {
+		return f, fmt.Errorf("%s: %s", file, e)
+	}
+	tm := func(s string) (time.Time, error) { return time.Parse(time.RFC3339, strings.TrimSpace(s)) }
+	start, e := tm(a.Id)
+	if e != nil && len(a.Lap) > 0 {
+		start, e = tm(a.Lap[0].StartTime)
+	}
+	if e != nil {
+		return f, fmt.Errorf("%s: start time: %s", file, e)
+	}
+	var seconds, meters float64
+	lat0, lon0, dist := math.NaN(), math.NaN(), 0.0
+	opt16 := func(p *uint16) uint16 {
+		if p == nil {
+			return invalidU16
+		}
+		return *p
+	}
+	for _, l := range a.Lap {
+		ls, e := tm(l.StartTime)
+		if e != nil {
+			return f, fmt.Errorf("%s: lap start: %s", file, e)
+		}
+		f.Laps = append(f.Laps, Lap{
+			Start:   float32(ls.Sub(start).Seconds()),
+			Seconds: float32(l.Seconds),
+			Meters:  float32(l.Meters),
+			Trigger: tcxTrigger(l.Trigger),
+		})
+		seconds += l.Seconds
+		meters += l.Meters
+		for _, p := range l.Pt {
+			t, e := tm(p.Time)
+			if e != nil {
+				return f, fmt.Errorf("%s: trackpoint time: %s", file, e)
+			}
+			lat, lon := math.NaN(), math.NaN()
+			if p.Lat != nil && p.Lon != nil {
+				lat, lon = *p.Lat, *p.Lon
+			}
+			if p.Dist != nil {
+				dist = *p.Dist
+			} else if !math.IsNaN(lat) && !math.IsNaN(lat0) {
+				dist += Vincenty(rad(lat0), rad(lon0), rad(lat), rad(lon))
+			}
+			if !math.IsNaN(lat) {
+				lat0, lon0 = lat, lon
+			}
+			alt, speed := float32(math.NaN()), float32(math.NaN())
+			if p.Alt != nil {
+				alt = float32(*p.Alt)
+			}
+			if p.Speed != nil {
+				speed = float32(*p.Speed)
+			}
+			hr := invalidU16
+			if p.Hr != 0 {
+				hr = p.Hr
+			}
+			f.Time = append(f.Time, float32(t.Sub(start).Seconds()))
+			f.Dist = append(f.Dist, float32(dist))
+			f.Alt = append(f.Alt, alt)
+			f.Lat = append(f.Lat, Semis(lat))
+			f.Lon = append(f.Lon, Semis(lon))
+			f.Hr = append(f.Hr, hr)
+			f.Cad = append(f.Cad, opt16(p.Cad))
+			f.Pow = append(f.Pow, opt16(p.Watts))
+			f.Speed = append(f.Speed, speed)
+		}
+	}
+	if meters == 0 && len(f.Dist) > 0 {
+		meters = float64(f.Dist[len(f.Dist)-1])
+	}
+	f.Header = Header{
+		Start:   start.Unix(),
+		Type:    typ,
+		Seconds: float32(seconds),
+		Meters:  float32(meters),
+		Samples: uint64(len(f.Time)),
+		Zone:    noZone,
+	}
+	f.dropInvalid()
+	return f, nil
+}
+func tcxTrigger(s string) uint8 {
+	switch s {
+	case "Time":
+		return uint8(fit.LapTriggerTime)
+	case "Distance":
+		return uint8(fit.LapTriggerDistance)
+	case "Location":
+		return uint8(fit.LapTriggerPositionLap)
+	}
+	return uint8(fit.LapTriggerManual)
+}
@@ -9,19 +9,23 @@ import (
 )
 
 type Tile struct {
-	run, bike []uint32 // lat,lon full-range web mercator projection
+	u [][]uint32 // per sport (index in sports): lat,lon full-range web mercator projection
 }
 
 func NewTile(db DB) (t Tile) {
+	t.u = make([][]uint32, len(sports))
 	Each(db, func(i int, f File) {
-		if f.Type == 1 {
-			t.run = append(t.run, f.WebMercator()...)
-		} else if f.Type == 2 {
-			t.bike = append(t.bike, f.WebMercator()...)
-		}
+		k := sportOf(f.Type)
+		t.u[k] = append(t.u[k], f.WebMercator()...)
 	})
 	return t
 }
+func (t Tile) points() (n int) {
+	for _, u := range t.u {
+		n += len(u) / 2
+	}
+	return n
+}
 
 func (t Tile) Png(w io.Writer, z, x, y uint32, tileType string) {
 	z = 24 - z
@@ -47,8 +51,9 @@ func (t Tile) Png(w io.Writer, z, x, y uint32, tileType string) {
 					}
 				}
 			}
-			draw(t.bike)
-			draw(t.run)
+			for i := len(t.u) - 1; i >= 0; i-- {
+				draw(t.u[i])
+			}
 			return im
 		}
 	} else if tileType == "inferno" {
@@ -70,8 +75,9 @@ func (t Tile) Png(w io.Writer, z, x, y uint32, tileType string) {
 					}
 				}
 			}
-			draw(t.bike)
-			draw(t.run)
+			for i := len(t.u) - 1; i >= 0; i-- {
+				draw(t.u[i])
+			}
 			return im
 		}
 	} else {
@@ -85,8 +91,9 @@ func (t Tile) Png(w io.Writer, z, x, y uint32, tileType string) {
 					}
 				}
 			}
-			draw(t.bike, green)
-			draw(t.run, red)
+			for i := len(t.u) - 1; i >= 0; i-- {
+				draw(t.u[i], sports[i].Color)
+			}
 			return im
 		}
 	}
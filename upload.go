@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// serveUpload adds a fit, gpx or tcx file (form field file, optional type) and redirects to its map.
+func (db *hdb) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "post a file", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<20)
+	file, fh, e := r.FormFile("file")
+	if e != nil {
+		http.Error(w, e.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	fs, e := readUpload(file, fh.Filename, r.FormValue("type"))
+	if e != nil {
+		http.Error(w, e.Error(), http.StatusBadRequest)
+		return
+	}
+	if e := db.add(fs); e != nil {
+		http.Error(w, fh.Filename+": "+e.Error(), http.StatusConflict)
+		return
+	}
+	// relative to the request url (http.Redirect would use the path without the athlete prefix)
+	ids := make([]string, len(fs))
+	for i, f := range fs {
+		ids[i] = strconv.FormatInt(f.Start, 10)
+	}
+	w.Header().Set("Location", "map.html?id="+strings.Join(ids, ",")+pa(r, "tile"))
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// readUpload decodes with ReadTrack from a temporary copy (the extension selects the reader).
+func readUpload(r io.Reader, name, sport string) ([]File, error) {
+	if isTrack(name) == false {
+		return nil, fmt.Errorf("%s: not a fit, gpx or tcx file", name)
+	}
+	t, e := os.CreateTemp("", "kyd-upload-*"+filepath.Ext(name))
+	if e != nil {
+		return nil, e
+	}
+	defer os.Remove(t.Name())
+	_, e = io.Copy(t, r)
+	if e = do(e, t.Close()); e != nil {
+		return nil, e
+	}
+	fs, e := ReadTrack(t.Name(), sport)
+	if e != nil {
+		e = fmt.Errorf("%s", strings.Replace(e.Error(), t.Name(), name, -1))
+	}
+	return fs, e
+}
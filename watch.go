@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// add adds the files of one track (see ReadTrack) to the DiskDB and updates the calendar, tiles and news.
+// A filtered db (-date, -tag) is read only: adding would shift the positions held by its SubDB.
+func (db *hdb) add(fs []File) error {
+	db.Lock()
+	defer db.Unlock()
+	d, o := db.DB.(*DiskDB)
+	if !o {
+		return fmt.Errorf("db is read only (filtered)")
+	}
+	if d.has(fs[0].Start) {
+		return fmt.Errorf("%d: exists", fs[0].Start)
+	}
+	if e := d.AddGroup(fs); e != nil {
+		return e
+	}
+	db.cal = Calendar(db.DB)
+	for _, f := range fs {
+		k := sportOf(f.Type)
+		db.tile.u[k] = append(db.tile.u[k], f.WebMercator()...)
+		addnews(db.news, f)
+	}
+	return nil
+}
+
+// watch polls dir for new track files and adds them.
+// A file is read when its size did not change since the last poll.
+// Files that fail are tried again only if they change.
+func (db *hdb) watch(dir string, every time.Duration) {
+	size := make(map[string]int64) // at the last poll
+	done := make(map[string]int64) // size when it was read
+	for ; ; time.Sleep(every) {
+		files, e := os.ReadDir(dir)
+		if e != nil {
+			log.Println("watch:", e)
+			continue
+		}
+		for _, fi := range files {
+			info, e := fi.Info()
+			if fi.IsDir() || !isTrack(fi.Name()) || e != nil {
+				continue
+			}
+			name, n := filepath.Join(dir, fi.Name()), info.Size()
+			last, o := size[name]
+			size[name] = n
+			if !o || last != n || done[name] == n {
+				continue
+			}
+			done[name] = n
+			fs, e := ReadTrack(name, "")
+			if e == nil {
+				db.Lock()
+				d, o := db.DB.(*DiskDB)
+				exists := o && d.has(fs[0].Start)
+				db.Unlock()
+				if exists {
+					continue
+				}
+				e = db.add(fs)
+			}
+			if e != nil {
+				log.Println("watch:", name, e)
+			} else {
+				for _, f := range fs {
+					log.Println("watch: added", f.Start, name)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tormoder/fit"
+)
+
+// zone is the configured time zone (db/zone.txt) for entries without an offset (Header.Zone == noZone).
+var zone = time.UTC
+
+const noZone int32 = -1 << 31
+
+// Local returns the start time in the activity's local time.
+func (h Header) Local() time.Time {
+	if h.Zone == noZone {
+		return time.Unix(h.Start, 0).In(zone)
+	}
+	return time.Unix(h.Start, 0).In(time.FixedZone("", int(h.Zone)))
+}
+
+// wall is the local start time as unix seconds, comparable to ParseSpan and race times.
+func (h Header) wall() int64 {
+	_, off := h.Local().Zone()
+	return h.Start + int64(off)
+}
+
+// readZone sets the configured zone from the file (e.g. Europe/Zurich), if it exists.
+func readZone(name string) error {
+	b, e := ioutil.ReadFile(name)
+	if os.IsNotExist(e) {
+		return nil
+	} else if e != nil {
+		return e
+	}
+	z, e := time.LoadLocation(strings.TrimSpace(string(b)))
+	if e != nil {
+		return e
+	}
+	zone = z
+	return nil
+}
+
+// zoneOffset returns the offset of the local wall clock, rounded to 15 minutes, or noZone if it is not plausible.
+// The fit decoder returns local timestamps in a fixed zone, or as the raw wall clock (zero offset).
+// Missing fit timestamps decode as the fit base time (1989-12-31).
+func zoneOffset(utc, local time.Time) int32 {
+	_, off := local.Zone()
+	d := local.Add(time.Duration(off) * time.Second).Sub(utc).Round(15 * time.Minute)
+	if utc.IsZero() || local.IsZero() || fit.IsBaseTime(utc) || fit.IsBaseTime(local) || d < -14*time.Hour || d > 14*time.Hour {
+		return noZone
+	}
+	return int32(d / time.Second)
+}